@@ -5,11 +5,15 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 
 	"github.com/product-scraper/internal/config"
+	"github.com/product-scraper/internal/discovery"
+	"github.com/product-scraper/internal/images"
 	"github.com/product-scraper/internal/models"
+	"github.com/product-scraper/internal/report"
 	"github.com/product-scraper/internal/scraper"
 	"github.com/product-scraper/internal/storage"
 	"github.com/product-scraper/internal/utils"
@@ -22,18 +26,108 @@ func main() {
 	// Initialize storage manager
 	storageManager := storage.NewManager(cfg)
 
-	// Load products from Excel file
-	log.Printf("Attempting to load Excel file from: %s", cfg.InputFile)
-	products, err := utils.LoadProductsFromExcel(cfg.InputFile)
-	if err != nil {
-		log.Fatalf("Failed to load products from Excel: %v", err)
+	// Products are streamed from the input by the producer below, so only
+	// check that it is there before starting any browsers
+	switch cfg.InputSource {
+	case "file":
+		log.Printf("Attempting to load input file from: %s", cfg.InputFile)
+		if cfg.InputFile != utils.StdinInput {
+			if _, err := os.Stat(cfg.InputFile); err != nil {
+				log.Fatalf("Failed to open input file: %v", err)
+			}
+		}
+	case "sitemap":
+		log.Printf("Discovering products from sitemap: %s", cfg.SitemapURL)
+	case "category":
+		log.Printf("Discovering products from %d category pages", len(cfg.CategoryURLs))
+	default:
+		log.Fatalf("Unknown INPUT_SOURCE %q (available: file, sitemap, category)", cfg.InputSource)
 	}
-	log.Printf("Loaded %d products from Excel file", len(products))
 
-	startIndex := 0 // Resume logic removed
+	// Results can only be written back into an input workbook, so a bad
+	// setting is caught before scraping rather than after
+	if cfg.WorkbookOutputFile != "" && (cfg.InputSource != "file" || !utils.IsExcelFile(cfg.InputFile)) {
+		log.Fatalf("WORKBOOK_OUTPUT_FILE requires INPUT_SOURCE=file with an Excel input file")
+	}
+
+	// The "validate" command only checks the input file; "retry" scrapes only
+	// the products listed in the previous run's failed URLs and "recover"
+	// rebuilds the output from the journal of a crashed run
+	var retryFilter func(models.Product) bool
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			runValidate(cfg, storageManager)
+			return
+		case "recover":
+			runRecover(storageManager)
+			return
+		case "retry":
+			// The journal of a crashed run is newer than the output files,
+			// so they are rebuilt from it first and its failures retried
+			recovered, err := recoverOutput(storageManager)
+			if err != nil {
+				log.Fatalf("Failed to recover journal: %v", err)
+			}
+			if recovered > 0 {
+				log.Printf("Recovered output for %d products from the journal before retrying.", recovered)
+				storageManager = storage.NewManager(cfg)
+			}
+			failedURLs, err := storageManager.LoadPreviousRun()
+			if err != nil {
+				log.Fatalf("Failed to load previous run: %v", err)
+			}
+			if len(failedURLs) == 0 {
+				log.Println("No failed URLs to retry.")
+				return
+			}
+			log.Printf("Retrying %d failed products", len(failedURLs))
+			retryFilter = utils.FailedURLFilter(failedURLs)
+			// The previous output is rewritten in place, so retry runs are
+			// neither resumed nor checkpointed
+			cfg.Resume = false
+			cfg.ResumeDataFile = ""
+		default:
+			log.Fatalf("Unknown command %q (available: validate, retry, recover)", os.Args[1])
+		}
+	}
+
+	// Pick up where an interrupted or crashed run stopped. The journal holds
+	// every result written, so the checkpoint is only needed without one.
+	completed := 0
+	if cfg.Resume {
+		var err error
+		if completed, err = storageManager.RecoverJournal(); err != nil {
+			log.Fatalf("Failed to recover journal: %v", err)
+		}
+		if completed == 0 {
+			if completed, err = storageManager.LoadResumeData(); err != nil {
+				log.Fatalf("Failed to load resume data: %v", err)
+			}
+		}
+		if completed > 0 {
+			log.Printf("Resuming: skipping %d products completed in the previous run", completed)
+		}
+	} else {
+		clearRunState(storageManager)
+	}
+
+	if err := storageManager.OpenJournal(); err != nil {
+		log.Fatalf("Failed to open journal: %v", err)
+	}
+
+	// Results fan out to every configured sink; file sinks keep what resumed
+	// and retried runs wrote before
+	sinks, err := storage.NewSinks(cfg, storageManager, completed > 0 || retryFilter != nil)
+	if err != nil {
+		log.Fatalf("Failed to create output sinks: %v", err)
+	}
 
 	// Initialize scraper
-	scraperInstance := scraper.New(cfg)
+	scraperInstance, err := scraper.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up scraper: %v", err)
+	}
 
 	// Setup graceful shutdown
 	ctx := setupGracefulShutdown()
@@ -42,14 +136,53 @@ func main() {
 	productChan := make(chan models.Product, cfg.BufferSize)
 	resultChan := make(chan models.ProductResult, cfg.BufferSize)
 
+	// Products repeating an ID or URL are collapsed before they reach a worker
+	dedup := utils.NewDeduplicator(cfg.DedupPolicy)
+	if completed > 0 {
+		storage.SeedAliasResults(storageManager, dedup)
+	}
+
 	var wg sync.WaitGroup // Main WaitGroup
 
+	// Set by the producer when the input cannot be read to the end; read
+	// only after wg.Wait
+	var producerErr error
+
+	// Successful results pass through the image validation and download
+	// stages, when enabled, on their way to the result processor
+	var processChan <-chan models.ProductResult = resultChan
+	if cfg.ValidateImages {
+		validator, err := images.NewValidator(cfg)
+		if err != nil {
+			log.Fatalf("Failed to set up image validation: %v", err)
+		}
+		in, validatedChan := processChan, make(chan models.ProductResult, cfg.BufferSize)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			validator.Run(ctx, in, validatedChan)
+		}()
+		processChan = validatedChan
+	}
+	if cfg.DownloadImages {
+		downloader, err := images.NewDownloader(cfg)
+		if err != nil {
+			log.Fatalf("Failed to set up image downloads: %v", err)
+		}
+		in, downloadedChan := processChan, make(chan models.ProductResult, cfg.BufferSize)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			downloader.Run(ctx, in, downloadedChan)
+		}()
+		processChan = downloadedChan
+	}
+
 	// Start result processor
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		// cfg might not be needed by processResults if SnapshotInterval was its only use
-		processResults(ctx, resultChan, storageManager) // cfg removed
+		storage.ProcessResults(ctx, processChan, sinks, dedup)
 	}()
 
 	// Start product producer
@@ -58,15 +191,46 @@ func main() {
 		defer wg.Done()
 		defer close(productChan) // Producer closes productChan when done
 
-		for i := startIndex; i < len(products); i++ {
+		// Products are sent as soon as they are parsed so scraping starts
+		// immediately and large inputs are never held in memory
+		index, skipped := 0, 0
+		validator := utils.NewValidator(inputName(cfg))
+		err := streamInput(ctx, cfg, func(product models.Product) error {
+			if !validator.Check(product) {
+				return nil
+			}
+			// Products completed in an earlier run still collapse their
+			// duplicates, and are never given an alias result again
+			if storageManager.IsCompleted(product.ID) {
+				dedup.AddCompleted(product)
+				skipped++
+				return nil
+			}
+			if !dedup.Add(product) {
+				return nil
+			}
+			if retryFilter != nil && !retryFilter(product) {
+				return nil
+			}
+			index++
 			select {
 			case <-ctx.Done():
-				log.Println("Producer: Context done, stopping product feed.")
-				return
-			case productChan <- products[i]:
+				return ctx.Err()
+			case productChan <- product:
+				return nil
 			}
+		})
+		switch {
+		case ctx.Err() != nil:
+			log.Println("Producer: Context done, stopping product feed.")
+		case err != nil:
+			producerErr = err
+			log.Printf("Producer: Failed to read products from input: %v", err)
+		default:
+			log.Printf("Producer: Finished sending all %d products (%d already completed).", index, skipped)
 		}
-		log.Println("Producer: Finished sending all products.")
+		finishValidation(validator, storageManager)
+		finishDedup(dedup, storageManager)
 	}()
 
 	// Start scraper workers and manage resultChan closure
@@ -93,41 +257,170 @@ func main() {
 	wg.Wait() // Wait for producer, processResults, and the worker manager (which closes resultChan)
 	log.Println("Main: All goroutines completed.")
 
+	// Duplicates read after their product finished still need its result
+	storage.WriteAliasResults(sinks, dedup)
+
 	// Clean up browser resources
 	scraperInstance.Cleanup()
 
+	// Keep the checkpoint of an interrupted run so the next run can resume
+	// it; the journal already holds everything when there is one
+	if (ctx.Err() != nil || producerErr != nil) && cfg.JournalFile == "" {
+		if err := storageManager.Checkpoint(); err != nil {
+			log.Printf("Failed to save resume data: %v", err)
+		} else {
+			log.Printf("Main: Interrupted; progress saved to %s", cfg.ResumeDataFile)
+		}
+	}
+
+	// A run whose input broke off is not finished: the previous output is
+	// left alone and the journal or checkpoint kept, so that the run can be
+	// resumed once the input is fixed
+	if producerErr != nil {
+		for _, sink := range sinks[1:] {
+			if err := sink.Close(); err != nil {
+				log.Printf("Failed to close output: %v", err)
+			}
+		}
+		if err := storageManager.CloseJournal(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		log.Fatalf("Failed to read products from input: %v", producerErr)
+	}
+
 	// Generate final output
 	log.Println("Main: Generating final output...")
-	if err := storageManager.GenerateFinalOutput(); err != nil {
-		log.Printf("Failed to generate final output: %v", err)
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			log.Printf("Failed to generate final output: %v", err)
+		}
+	}
+
+	if cfg.WorkbookOutputFile != "" {
+		if err := storageManager.WriteWorkbook(inputOptions(cfg)); err != nil {
+			log.Printf("Failed to write results back to the input workbook: %v", err)
+		}
+	}
+
+	if cfg.ReportFile != "" {
+		err := report.New(cfg).Write(ctx, storageManager.Results(), storageManager.FailedURLs())
+		if err != nil {
+			log.Printf("Failed to write report: %v", err)
+		}
+	}
+
+	if ctx.Err() == nil {
+		clearRunState(storageManager)
 	}
 
 	log.Println("Scraping completed successfully.")
 }
 
-func processResults(ctx context.Context, resultChan <-chan models.ProductResult, storage *storage.Manager) { // cfg *config.Config removed
-	log.Println("ProcessResults: Started.")
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("ProcessResults: Context done, stopping.")
-			return
-		case result, ok := <-resultChan:
-			if !ok {
-				log.Println("ProcessResults: Result channel closed, stopping.")
-				return
-			}
+// runValidate checks every input row without scraping and writes the validation report
+func runValidate(cfg *config.Config, storageManager *storage.Manager) {
+	validator := utils.NewValidator(inputName(cfg))
+	err := streamInput(context.Background(), cfg, func(product models.Product) error {
+		validator.Check(product)
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Failed to read products from input: %v", err)
+	}
+	finishValidation(validator, storageManager)
+}
 
-			if result.Success {
-				storage.SaveResult(result)
-			} else {
-				// Attempt to find the original product URL. This is a bit tricky as ProductResult doesn't store it.
-				// For now, we'll use a placeholder if the URL isn't easily available.
-				// This part might need adjustment based on how you want to log failed URLs.
-				log.Printf("ProcessResults: Received failed result for ID %s: %s", result.ID, result.Error)
-				storage.SaveFailedURL(result.ID, "N/A - URL not in ProductResult", result.Error)
-			}
+// runRecover rebuilds the final output and failed URLs from the journal left
+// by a crashed run. The journal is kept so that the run can still be resumed.
+func runRecover(storageManager *storage.Manager) {
+	recovered, err := recoverOutput(storageManager)
+	if err != nil {
+		log.Fatalf("Failed to recover journal: %v", err)
+	}
+	if recovered == 0 {
+		log.Println("No journal to recover.")
+		return
+	}
+	log.Printf("Recovered output for %d products.", recovered)
+}
+
+// recoverOutput rebuilds the final output and failed URLs from the journal,
+// if there is one, and returns the number of products recovered
+func recoverOutput(storageManager *storage.Manager) (int, error) {
+	recovered, err := storageManager.RecoverJournal()
+	if err != nil || recovered == 0 {
+		return recovered, err
+	}
+	return recovered, storageManager.GenerateFinalOutput()
+}
+
+// clearRunState removes the resume checkpoint and journal of a previous run
+func clearRunState(storageManager *storage.Manager) {
+	if err := storageManager.ClearResumeData(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	if err := storageManager.ClearJournal(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// finishValidation prints the validation summary and saves the full report
+func finishValidation(validator *utils.Validator, storageManager *storage.Manager) {
+	validator.LogSummary(20)
+	if err := storageManager.SaveValidationReport(validator.Report()); err != nil {
+		log.Printf("Failed to save validation report: %v", err)
+	}
+}
+
+// finishDedup logs and saves what deduplication collapsed
+func finishDedup(dedup *utils.Deduplicator, storageManager *storage.Manager) {
+	records := dedup.Records()
+	log.Printf("Deduplication: %d products collapsed", len(records))
+	if err := storageManager.SaveDedupReport(records); err != nil {
+		log.Printf("Failed to save dedup report: %v", err)
+	}
+}
+
+// streamInput passes every product from the configured input source to fn
+func streamInput(ctx context.Context, cfg *config.Config, fn utils.ProductHandler) error {
+	switch cfg.InputSource {
+	case "sitemap":
+		sitemap, err := discovery.NewSitemap(cfg)
+		if err != nil {
+			return err
+		}
+		return sitemap.Stream(ctx, fn)
+	case "category":
+		crawler, err := discovery.NewCategoryCrawler(cfg)
+		if err != nil {
+			return err
 		}
+		return crawler.Stream(ctx, fn)
+	default:
+		return utils.StreamProducts(cfg.InputFile, inputOptions(cfg), fn)
+	}
+}
+
+// inputName describes the input source in reports
+func inputName(cfg *config.Config) string {
+	switch cfg.InputSource {
+	case "sitemap":
+		return cfg.SitemapURL
+	case "category":
+		return strings.Join(cfg.CategoryURLs, ", ")
+	default:
+		return cfg.InputFile
+	}
+}
+
+// inputOptions maps the input layout settings onto the loader options
+func inputOptions(cfg *config.Config) utils.InputOptions {
+	return utils.InputOptions{
+		Sheet:      cfg.InputSheet,
+		AllSheets:  cfg.InputAllSheets,
+		IDColumn:   cfg.IDColumn,
+		LinkColumn: cfg.LinkColumn,
+		HeaderRow:  cfg.HeaderRow,
+		IDAsText:   cfg.IDAsText,
 	}
 }
 
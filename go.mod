@@ -6,6 +6,7 @@ require (
 	github.com/chromedp/chromedp v0.9.5
 	github.com/joho/godotenv v1.5.1
 	github.com/xuri/excelize/v2 v2.8.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv" // Import the godotenv package
@@ -16,6 +17,94 @@ type Config struct {
 	OutputDir       string
 	FinalOutputFile string
 	FailedURLsFile  string
+	// ValidationReportFile receives the per-row input validation report
+	ValidationReportFile string
+	// DedupReportFile records the products collapsed before scraping
+	DedupReportFile string
+
+	// Resume: completed products and partial results are checkpointed to
+	// ResumeDataFile every CheckpointInterval products
+	Resume             bool
+	ResumeDataFile     string
+	CheckpointInterval int
+
+	// Every result is appended to JournalFile as it arrives and fsynced every
+	// JournalSyncInterval entries; the final output is built from it
+	JournalFile         string
+	JournalSyncInterval int
+
+	// Output sinks results are written to: json (always on), jsonl, csv, http
+	OutputSinks       []string
+	JSONLOutputFile   string
+	CSVOutputFile     string
+	HTTPSinkURL       string
+	HTTPSinkToken     string
+	HTTPSinkBatchSize int
+
+	// WorkbookOutputFile receives a copy of the input workbook with the
+	// results appended to each product's row; empty disables it
+	WorkbookOutputFile string
+
+	// ReportFile receives the QA workbook with the first ReportThumbnails
+	// images of every product embedded; empty disables it. Images are cached
+	// in ReportImageDir.
+	ReportFile       string
+	ReportThumbnails int
+	ReportImageDir   string
+
+	// Image downloads: files are stored under ImageDir following
+	// ImagePathLayout, ImageDownloadWorkers products at a time
+	DownloadImages       bool
+	ImageDir             string
+	ImagePathLayout      string
+	ImageDownloadWorkers int
+	// ImageStorage is "layout" to store images by ImagePathLayout or "hash"
+	// to store each distinct image once under its SHA-256, listed in
+	// ImageManifestFile
+	ImageStorage      string
+	ImageManifestFile string
+	// ValidateImages checks every image URL before download and removes, or
+	// with ImageValidationMode "flag" only reports, broken images and images
+	// smaller than ImageMinWidth x ImageMinHeight
+	ValidateImages      bool
+	ImageValidationMode string
+	ImageMinWidth       int
+	ImageMinHeight      int
+	// DedupImages collapses images of a product whose perceptual hashes
+	// differ in at most ImageDuplicateThreshold bits
+	DedupImages             bool
+	ImageDuplicateThreshold int
+
+	// Input source: "file" reads InputFile, "sitemap" discovers products from
+	// SitemapURL and "category" crawls the listing pages in CategoryURLs
+	InputSource string
+
+	// Sitemap discovery
+	SitemapURL        string
+	SitemapURLPattern string
+	SitemapIDPattern  string
+
+	// Category crawling
+	CategoryURLs             []string
+	CategoryLinkSelector     string
+	CategoryURLPattern       string
+	CategoryIDAttribute      string
+	CategoryIDPattern        string
+	CategoryNextSelector     string
+	CategoryLoadMoreSelector string
+	CategoryInfiniteScroll   bool
+	CategoryMaxPages         int
+
+	// Input layout
+	InputSheet     string
+	InputAllSheets bool
+	IDColumn       string
+	LinkColumn     string
+	HeaderRow      int
+	IDAsText       bool
+
+	// Deduplication policy for different IDs sharing a URL: first, alias, all or off
+	DedupPolicy string
 
 	// Scraping settings
 	WorkerCount    int
@@ -23,10 +112,24 @@ type Config struct {
 	RequestTimeout time.Duration
 	PageLoadDelay  time.Duration
 
-	// Browser settings
-	BrowserFlags []string
-	MaxRetries   int
-	RetryDelay   time.Duration
+	// Browser settings. BrowserFlags are Chrome switches such as
+	// "--window-size=1920,1080"; "--name=false" removes a default switch.
+	// With DisableDefaultBrowserFlags only BrowserFlags are passed to Chrome.
+	// ChromePath overrides the Chrome binary found on the PATH.
+	// BrowserStartTimeout bounds how long Chrome may take to start.
+	BrowserFlags               []string
+	DisableDefaultBrowserFlags bool
+	ChromePath                 string
+	BrowserStartTimeout        time.Duration
+	MaxRetries                 int
+	RetryDelay                 time.Duration
+	// BrowserPoolSize long-lived browsers are shared by the workers; each is
+	// restarted after BrowserRecyclePages pages (0 never restarts)
+	BrowserPoolSize     int
+	BrowserRecyclePages int
+	// SiteProfilesDir holds YAML or JSON files describing where the images
+	// are on each site; links no profile matches use the default profile
+	SiteProfilesDir string
 }
 
 // Load loads configuration from environment variables or uses defaults
@@ -58,10 +161,72 @@ func Load() *Config {
 	}
 	cfg := &Config{
 		// Default values
-		InputFile:       getEnv("INPUT_FILE", filepath.Join(projectRoot, "samples.xlsx")),
-		OutputDir:       getEnv("OUTPUT_DIR", "output"),
-		FinalOutputFile: getEnv("FINAL_OUTPUT_FILE", "output/final_output.json"),
-		FailedURLsFile:  getEnv("FAILED_URLS_FILE", "output/failed_urls.json"),
+		InputFile:            getEnv("INPUT_FILE", filepath.Join(projectRoot, "samples.xlsx")),
+		OutputDir:            getEnv("OUTPUT_DIR", "output"),
+		FinalOutputFile:      getEnv("FINAL_OUTPUT_FILE", "output/final_output.json"),
+		FailedURLsFile:       getEnv("FAILED_URLS_FILE", "output/failed_urls.json"),
+		ValidationReportFile: getEnv("VALIDATION_REPORT_FILE", "output/validation_report.json"),
+		DedupReportFile:      getEnv("DEDUP_REPORT_FILE", "output/dedup_report.json"),
+		// Resume an interrupted run from its checkpoint
+		Resume:             getEnvBool("RESUME", true),
+		ResumeDataFile:     getEnv("RESUME_DATA_FILE", "output/resume_data.json"),
+		CheckpointInterval: getEnvInt("CHECKPOINT_INTERVAL", 50),
+		// Crash-safe results journal; an empty file name disables it
+		JournalFile:         getEnv("JOURNAL_FILE", "output/results.jsonl"),
+		JournalSyncInterval: getEnvInt("JOURNAL_SYNC_INTERVAL", 10),
+		// Additional outputs written as results arrive
+		OutputSinks:       getEnvList("OUTPUT_SINKS"),
+		JSONLOutputFile:   getEnv("JSONL_OUTPUT_FILE", "output/final_output.jsonl"),
+		CSVOutputFile:     getEnv("CSV_OUTPUT_FILE", "output/final_output.csv"),
+		HTTPSinkURL:       getEnv("HTTP_SINK_URL", ""),
+		HTTPSinkToken:     getEnv("HTTP_SINK_TOKEN", ""),
+		HTTPSinkBatchSize: getEnvInt("HTTP_SINK_BATCH_SIZE", 50),
+		// Results written back into a copy of the input workbook
+		WorkbookOutputFile: getEnv("WORKBOOK_OUTPUT_FILE", ""),
+		// QA report with embedded thumbnails
+		ReportFile:       getEnv("REPORT_FILE", ""),
+		ReportThumbnails: getEnvInt("REPORT_THUMBNAILS", 3),
+		ReportImageDir:   getEnv("REPORT_IMAGE_DIR", "output/report_images"),
+		// Local copies of the scraped images
+		DownloadImages:       getEnvBool("DOWNLOAD_IMAGES", false),
+		ImageDir:             getEnv("IMAGE_DIR", "output/images"),
+		ImagePathLayout:      getEnv("IMAGE_PATH_LAYOUT", "{id}/{index}.{ext}"),
+		ImageDownloadWorkers: getEnvInt("IMAGE_DOWNLOAD_WORKERS", 4),
+		ImageStorage:         getEnv("IMAGE_STORAGE", "layout"),
+		ImageManifestFile:    getEnv("IMAGE_MANIFEST_FILE", "output/images/manifest.json"),
+		// Image URL validation
+		ValidateImages:      getEnvBool("VALIDATE_IMAGES", false),
+		ImageValidationMode: getEnv("IMAGE_VALIDATION_MODE", "remove"),
+		ImageMinWidth:       getEnvInt("IMAGE_MIN_WIDTH", 100),
+		ImageMinHeight:      getEnvInt("IMAGE_MIN_HEIGHT", 100),
+		// Near-duplicate images within a product, by perceptual hash
+		DedupImages:             getEnvBool("DEDUP_IMAGES", false),
+		ImageDuplicateThreshold: getEnvInt("IMAGE_DUPLICATE_THRESHOLD", 6),
+		// Where products come from: the input file, a sitemap or category pages
+		InputSource: getEnv("INPUT_SOURCE", "file"),
+		// Sitemap discovery; the ID defaults to the last path segment of the URL
+		SitemapURL:        getEnv("SITEMAP_URL", ""),
+		SitemapURLPattern: getEnv("SITEMAP_URL_PATTERN", ""),
+		SitemapIDPattern:  getEnv("SITEMAP_ID_PATTERN", ""),
+		// Category crawling; pagination uses the next-page link, the "load more"
+		// button or infinite scroll, whichever is configured first
+		CategoryURLs:             getEnvList("CATEGORY_URLS"),
+		CategoryLinkSelector:     getEnv("CATEGORY_LINK_SELECTOR", "a[href]"),
+		CategoryURLPattern:       getEnv("CATEGORY_URL_PATTERN", ""),
+		CategoryIDAttribute:      getEnv("CATEGORY_ID_ATTRIBUTE", ""),
+		CategoryIDPattern:        getEnv("CATEGORY_ID_PATTERN", ""),
+		CategoryNextSelector:     getEnv("CATEGORY_NEXT_SELECTOR", ""),
+		CategoryLoadMoreSelector: getEnv("CATEGORY_LOAD_MORE_SELECTOR", ""),
+		CategoryInfiniteScroll:   getEnvBool("CATEGORY_INFINITE_SCROLL", false),
+		CategoryMaxPages:         getEnvInt("CATEGORY_MAX_PAGES", 10),
+		// Input layout; empty columns fall back to the built-in header aliases
+		InputSheet:     getEnv("INPUT_SHEET", ""),
+		InputAllSheets: getEnvBool("INPUT_ALL_SHEETS", false),
+		IDColumn:       getEnv("ID_COLUMN", ""),
+		LinkColumn:     getEnv("LINK_COLUMN", ""),
+		HeaderRow:      getEnvInt("HEADER_ROW", 1),
+		IDAsText:       getEnvBool("ID_AS_TEXT", false),
+		DedupPolicy:    getEnv("DEDUP_POLICY", "first"),
 		// Match working script settings exactly
 		WorkerCount: getEnvInt("WORKER_COUNT", 5),
 		BufferSize:  getEnvInt("BUFFER_SIZE", 100),
@@ -69,12 +234,20 @@ func Load() *Config {
 		RequestTimeout: time.Duration(getEnvInt("REQUEST_TIMEOUT_SECONDS", 15)) * time.Second,
 		PageLoadDelay:  time.Duration(getEnvInt("PAGE_LOAD_DELAY_MS", 2000)) * time.Millisecond,
 
-		BrowserFlags: getBrowserFlags(),
+		DisableDefaultBrowserFlags: !getEnvBool("BROWSER_DEFAULT_FLAGS", true),
+		ChromePath:                 getEnv("CHROME_PATH", ""),
+		BrowserStartTimeout:        time.Duration(getEnvInt("BROWSER_START_TIMEOUT_SECONDS", 30)) * time.Second,
 		// Match working script retry settings
 		MaxRetries: getEnvInt("MAX_RETRIES", 3),
 		// Match working script retry delay
 		RetryDelay: time.Duration(getEnvInt("RETRY_DELAY_SECONDS", 2)) * time.Second,
+		// Shared browsers
+		BrowserPoolSize:     getEnvInt("BROWSER_POOL_SIZE", 1),
+		BrowserRecyclePages: getEnvInt("BROWSER_RECYCLE_PAGES", 100),
+		// Per-site extraction
+		SiteProfilesDir: getEnv("SITE_PROFILES_DIR", ""),
 	}
+	cfg.BrowserFlags = getBrowserFlags(cfg.DisableDefaultBrowserFlags)
 
 	// Create directories if they don't exist
 	createDirectoryIfNotExists(cfg.OutputDir)
@@ -100,21 +273,58 @@ func getEnvInt(key string, fallback int) int {
 	return fallback
 }
 
-func getBrowserFlags() []string {
-	defaultFlags := []string{
-		"--headless",
-		"--disable-gpu",
-		"--no-sandbox",
-		"--disable-dev-shm-usage",
+// getEnvList splits a comma separated variable, dropping empty entries
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, ""), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
 	}
+	return list
+}
 
-	if customFlagsStr := getEnv("BROWSER_FLAGS", ""); customFlagsStr != "" {
-		// Parse custom flags (comma separated)
-		// This is simplified; you might want a more robust parsing
-		return append(defaultFlags, customFlagsStr)
+func getEnvBool(key string, fallback bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if boolVal, err := strconv.ParseBool(value); err == nil {
+			return boolVal
+		}
+		log.Printf("Warning: Invalid value for %s, using default: %t", key, fallback)
 	}
+	return fallback
+}
 
-	return defaultFlags
+// getBrowserFlags returns the default Chrome switches, unless disableDefaults
+// is set, followed by the comma separated switches in BROWSER_FLAGS. A comma
+// not followed by a switch is part of the previous value, as in
+// "--window-size=1920,1080".
+func getBrowserFlags(disableDefaults bool) []string {
+	var flags []string
+	if !disableDefaults {
+		flags = []string{
+			"--headless",
+			"--disable-gpu",
+			"--no-sandbox",
+			"--disable-dev-shm-usage",
+		}
+	}
+
+	custom := getEnv("BROWSER_FLAGS", "")
+	if custom == "" {
+		return flags
+	}
+	first := len(flags)
+	for _, part := range strings.Split(custom, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+		case strings.HasPrefix(part, "-") || len(flags) == first:
+			flags = append(flags, "--"+strings.TrimLeft(part, "-"))
+		default:
+			flags[len(flags)-1] += "," + part
+		}
+	}
+	return flags
 }
 
 func createDirectoryIfNotExists(path string) {
@@ -0,0 +1,197 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+	"github.com/product-scraper/internal/config"
+	"github.com/product-scraper/internal/models"
+	"github.com/product-scraper/internal/scraper"
+	"github.com/product-scraper/internal/utils"
+)
+
+// CategoryCrawler discovers products by opening category listing pages in a
+// headless browser, following pagination, "load more" buttons or infinite
+// scroll, and collecting the product links on each page
+type CategoryCrawler struct {
+	config     *config.Config
+	urlPattern *regexp.Regexp
+	idPattern  *regexp.Regexp
+}
+
+// categoryLink is a product link found on a listing page
+type categoryLink struct {
+	Href string `json:"href"`
+	ID   string `json:"id"`
+}
+
+// NewCategoryCrawler creates a category source from the CATEGORY_* settings
+func NewCategoryCrawler(cfg *config.Config) (*CategoryCrawler, error) {
+	if len(cfg.CategoryURLs) == 0 {
+		return nil, fmt.Errorf("CATEGORY_URLS is required for the category input source")
+	}
+
+	c := &CategoryCrawler{config: cfg}
+
+	var err error
+	if cfg.CategoryURLPattern != "" {
+		if c.urlPattern, err = regexp.Compile(cfg.CategoryURLPattern); err != nil {
+			return nil, fmt.Errorf("invalid CATEGORY_URL_PATTERN: %v", err)
+		}
+	}
+	if cfg.CategoryIDPattern != "" {
+		if c.idPattern, err = regexp.Compile(cfg.CategoryIDPattern); err != nil {
+			return nil, fmt.Errorf("invalid CATEGORY_ID_PATTERN: %v", err)
+		}
+	}
+
+	return c, nil
+}
+
+// Stream crawls every category URL and passes each product link found to fn.
+// Links already seen on an earlier page or category are skipped.
+func (c *CategoryCrawler) Stream(ctx context.Context, fn utils.ProductHandler) error {
+	seen := make(map[string]bool)
+	row := 0
+
+	for _, categoryURL := range c.config.CategoryURLs {
+		err := c.crawlCategory(ctx, categoryURL, func(link categoryLink) error {
+			if seen[link.Href] || (c.urlPattern != nil && !c.urlPattern.MatchString(link.Href)) {
+				return nil
+			}
+			seen[link.Href] = true
+
+			id := strings.TrimSpace(link.ID)
+			if id == "" {
+				id = idFromURL(link.Href, c.idPattern)
+			}
+
+			row++
+			return fn(models.Product{
+				ID:       id,
+				Link:     link.Href,
+				Metadata: map[string]string{"category": categoryURL},
+				Row:      row,
+			})
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			// One broken category should not stop the others
+			log.Printf("Category: Failed to crawl %s: %v", categoryURL, err)
+		}
+	}
+
+	return nil
+}
+
+// crawlCategory walks the pages of a single category
+func (c *CategoryCrawler) crawlCategory(ctx context.Context, categoryURL string, fn func(categoryLink) error) error {
+	browserCtx, cancel, err := scraper.NewBrowser(ctx, c.config)
+	if err != nil {
+		return fmt.Errorf("failed to start browser: %v", err)
+	}
+	defer cancel()
+
+	if err := c.run(browserCtx, chromedp.Navigate(categoryURL), chromedp.WaitReady("body", chromedp.ByQuery)); err != nil {
+		return fmt.Errorf("failed to open %s: %v", categoryURL, err)
+	}
+
+	for page := 1; page <= c.config.CategoryMaxPages; page++ {
+		var links []categoryLink
+		if err := c.run(browserCtx, chromedp.Sleep(c.config.PageLoadDelay), chromedp.Evaluate(c.collectScript(), &links)); err != nil {
+			return fmt.Errorf("failed to collect links on page %d: %v", page, err)
+		}
+
+		found := 0
+		for _, link := range links {
+			if link.Href == "" {
+				continue
+			}
+			found++
+			if err := fn(link); err != nil {
+				return err
+			}
+		}
+		log.Printf("Category: %s page %d: %d product links", categoryURL, page, found)
+
+		more, err := c.nextPage(browserCtx)
+		if err != nil {
+			return fmt.Errorf("failed to advance past page %d: %v", page, err)
+		}
+		if !more {
+			break
+		}
+	}
+
+	return nil
+}
+
+// nextPage advances the listing using the configured mechanism and reports
+// whether there may be more product links to collect
+func (c *CategoryCrawler) nextPage(ctx context.Context) (bool, error) {
+	switch {
+	case c.config.CategoryNextSelector != "":
+		var href string
+		script := fmt.Sprintf(`(() => { const el = document.querySelector(%q); return el && el.href ? el.href : ""; })()`,
+			c.config.CategoryNextSelector)
+		if err := c.run(ctx, chromedp.Evaluate(script, &href)); err != nil {
+			return false, err
+		}
+		if href == "" {
+			return false, nil
+		}
+		return true, c.run(ctx, chromedp.Navigate(href), chromedp.WaitReady("body", chromedp.ByQuery))
+
+	case c.config.CategoryLoadMoreSelector != "":
+		var clicked bool
+		script := fmt.Sprintf(`(() => { const el = document.querySelector(%q); if (!el || el.disabled || el.offsetParent === null) return false; el.click(); return true; })()`,
+			c.config.CategoryLoadMoreSelector)
+		if err := c.run(ctx, chromedp.Evaluate(script, &clicked)); err != nil {
+			return false, err
+		}
+		return clicked, nil
+
+	case c.config.CategoryInfiniteScroll:
+		var before, after int
+		if err := c.run(ctx,
+			chromedp.Evaluate(`document.body.scrollHeight`, &before),
+			chromedp.Evaluate(`window.scrollTo(0, document.body.scrollHeight)`, nil),
+			chromedp.Sleep(c.config.PageLoadDelay),
+			chromedp.Evaluate(`document.body.scrollHeight`, &after),
+		); err != nil {
+			return false, err
+		}
+		return after > before, nil
+	}
+
+	return false, nil
+}
+
+// collectScript returns the JavaScript collecting product links and, when an
+// ID attribute is configured, the ID from the link or its closest ancestor
+func (c *CategoryCrawler) collectScript() string {
+	return fmt.Sprintf(`
+		Array.from(document.querySelectorAll(%q)).map(el => {
+			const attr = %q;
+			let id = "";
+			if (attr) {
+				const holder = el.hasAttribute(attr) ? el : el.closest("[" + attr + "]");
+				id = holder ? holder.getAttribute(attr) : "";
+			}
+			return { href: el.href || "", id: id || "" };
+		})
+	`, c.config.CategoryLinkSelector, c.config.CategoryIDAttribute)
+}
+
+// run executes browser actions with the request timeout applied
+func (c *CategoryCrawler) run(ctx context.Context, actions ...chromedp.Action) error {
+	timeoutCtx, cancel := context.WithTimeout(ctx, c.config.RequestTimeout)
+	defer cancel()
+	return chromedp.Run(timeoutCtx, actions...)
+}
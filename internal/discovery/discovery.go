@@ -0,0 +1,33 @@
+// Package discovery finds product pages to scrape from sources other than an
+// input file, such as sitemaps and category listing pages.
+package discovery
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// idFromURL derives a product ID from a page URL: the first capture group of
+// pattern (or the whole match), else the last path segment. URLs not matching
+// the pattern get an empty ID so that validation reports them.
+func idFromURL(link string, pattern *regexp.Regexp) string {
+	if pattern != nil {
+		match := pattern.FindStringSubmatch(link)
+		switch {
+		case match == nil:
+			return ""
+		case len(match) > 1:
+			return match[1]
+		default:
+			return match[0]
+		}
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return path.Base(strings.TrimRight(u.Path, "/"))
+}
@@ -0,0 +1,192 @@
+package discovery
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/product-scraper/internal/config"
+	"github.com/product-scraper/internal/models"
+	"github.com/product-scraper/internal/utils"
+)
+
+// sitemapNamespace is the XML namespace of the sitemaps.org protocol
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// Sitemap discovers products from a sitemap.xml, following sitemap indexes
+// and reading gzipped sitemaps
+type Sitemap struct {
+	config     *config.Config
+	client     *http.Client
+	urlPattern *regexp.Regexp
+	idPattern  *regexp.Regexp
+}
+
+// NewSitemap creates a sitemap source from the SITEMAP_* settings
+func NewSitemap(cfg *config.Config) (*Sitemap, error) {
+	if cfg.SitemapURL == "" {
+		return nil, fmt.Errorf("SITEMAP_URL is required for the sitemap input source")
+	}
+
+	s := &Sitemap{
+		config: cfg,
+		client: &http.Client{Timeout: cfg.RequestTimeout},
+	}
+
+	var err error
+	if cfg.SitemapURLPattern != "" {
+		if s.urlPattern, err = regexp.Compile(cfg.SitemapURLPattern); err != nil {
+			return nil, fmt.Errorf("invalid SITEMAP_URL_PATTERN: %v", err)
+		}
+	}
+	if cfg.SitemapIDPattern != "" {
+		if s.idPattern, err = regexp.Compile(cfg.SitemapIDPattern); err != nil {
+			return nil, fmt.Errorf("invalid SITEMAP_ID_PATTERN: %v", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Stream walks the sitemap and passes a product to fn for every page URL
+// matching the URL pattern
+func (s *Sitemap) Stream(ctx context.Context, fn utils.ProductHandler) error {
+	visited := make(map[string]bool)
+	row := 0
+
+	var walk func(sitemapURL string) error
+	walk = func(sitemapURL string) error {
+		if visited[sitemapURL] {
+			return nil
+		}
+		visited[sitemapURL] = true
+
+		children, err := s.readSitemap(ctx, sitemapURL, func(loc string) error {
+			if s.urlPattern != nil && !s.urlPattern.MatchString(loc) {
+				return nil
+			}
+			row++
+			return fn(models.Product{
+				ID:       idFromURL(loc, s.idPattern),
+				Link:     loc,
+				Metadata: map[string]string{"sitemap": sitemapURL},
+				Row:      row,
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, child := range children {
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return walk(s.config.SitemapURL)
+}
+
+// readSitemap fetches one sitemap, passing page URLs to fn, and returns the
+// child sitemaps listed if it is a sitemap index
+func (s *Sitemap) readSitemap(ctx context.Context, sitemapURL string, fn func(loc string) error) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sitemapURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sitemap URL %s: %v", sitemapURL, err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sitemap %s: %v", sitemapURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch sitemap %s: status %s", sitemapURL, resp.Status)
+	}
+
+	body, err := decompress(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sitemap %s: %v", sitemapURL, err)
+	}
+
+	log.Printf("Sitemap: reading %s", sitemapURL)
+
+	var children []string
+	isIndex := false
+	// Open elements, so that only <loc> directly inside <url> or <sitemap>
+	// is taken and extensions such as <image:loc> are not
+	var open []xml.Name
+	decoder := xml.NewDecoder(body)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sitemap %s: %v", sitemapURL, err)
+		}
+
+		if _, ok := token.(xml.EndElement); ok && len(open) > 0 {
+			open = open[:len(open)-1]
+			continue
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Space != sitemapNamespace || start.Name.Local != "loc" {
+			open = append(open, start.Name)
+		}
+		if start.Name.Space != sitemapNamespace {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "sitemapindex":
+			isIndex = true
+		case "loc":
+			if len(open) == 0 || open[len(open)-1].Space != sitemapNamespace ||
+				(open[len(open)-1].Local != "url" && open[len(open)-1].Local != "sitemap") {
+				if err := decoder.Skip(); err != nil {
+					return nil, fmt.Errorf("failed to parse sitemap %s: %v", sitemapURL, err)
+				}
+				continue
+			}
+			var loc string
+			if err := decoder.DecodeElement(&loc, &start); err != nil {
+				return nil, fmt.Errorf("failed to parse sitemap %s: %v", sitemapURL, err)
+			}
+			loc = strings.TrimSpace(loc)
+			if loc == "" {
+				continue
+			}
+			if isIndex {
+				children = append(children, loc)
+			} else if err := fn(loc); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return children, nil
+}
+
+// decompress transparently gunzips sitemaps served as .xml.gz, detected by
+// the gzip magic number rather than the file name or headers
+func decompress(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(buffered)
+	}
+	return buffered, nil
+}
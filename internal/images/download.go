@@ -0,0 +1,382 @@
+// Package images downloads the scraped product images to local storage.
+package images
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/product-scraper/internal/config"
+	"github.com/product-scraper/internal/models"
+)
+
+// extensions maps image content types to file extensions
+var extensions = map[string]string{
+	"image/jpeg":    ".jpg",
+	"image/jpg":     ".jpg",
+	"image/pjpeg":   ".jpg",
+	"image/png":     ".png",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/avif":    ".avif",
+	"image/bmp":     ".bmp",
+	"image/tiff":    ".tiff",
+	"image/svg+xml": ".svg",
+}
+
+// unknownExtension is used when neither the content type nor the URL tells
+// what kind of image was downloaded
+const unknownExtension = ".img"
+
+// unsafeChars are replaced in product IDs used as path segments
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Downloader fetches the images of successful results and records the local
+// copies in the result
+type Downloader struct {
+	config *config.Config
+	client *http.Client
+	store  *Store
+}
+
+// NewDownloader creates an image downloader. With IMAGE_STORAGE=hash the
+// images go to the content-addressed store.
+func NewDownloader(cfg *config.Config) (*Downloader, error) {
+	d := &Downloader{
+		config: cfg,
+		client: &http.Client{Timeout: cfg.RequestTimeout},
+	}
+
+	switch cfg.ImageStorage {
+	case "", "layout":
+	case "hash":
+		store, err := NewStore(cfg)
+		if err != nil {
+			return nil, err
+		}
+		d.store = store
+	default:
+		return nil, fmt.Errorf("unknown IMAGE_STORAGE %q (available: layout, hash)", cfg.ImageStorage)
+	}
+
+	return d, nil
+}
+
+// Run downloads the images of the results read from in, ImageDownloadWorkers
+// products at a time, and passes every result on to out. It closes out when
+// in is closed or the context is done.
+func (d *Downloader) Run(ctx context.Context, in <-chan models.ProductResult, out chan<- models.ProductResult) {
+	runStage(ctx, d.config.ImageDownloadWorkers, in, out, d.Download)
+
+	if d.store != nil {
+		if err := d.store.Save(); err != nil {
+			log.Printf("Images: %v", err)
+		}
+	}
+}
+
+// runStage passes the successful results read from in through fn, workers
+// at a time, and sends every result on to out. It closes out when in is
+// closed or the context is done.
+func runStage(ctx context.Context, workers int, in <-chan models.ProductResult, out chan<- models.ProductResult,
+	fn func(context.Context, models.ProductResult) models.ProductResult) {
+	defer close(out)
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case result, ok := <-in:
+					if !ok {
+						return
+					}
+					if result.Success {
+						result = fn(ctx, result)
+					}
+					select {
+					case <-ctx.Done():
+						return
+					case out <- result:
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Download fetches every image of a result. Failed downloads are recorded
+// with their error and do not fail the result.
+func (d *Downloader) Download(ctx context.Context, result models.ProductResult) models.ProductResult {
+	files := make([]models.ImageFile, 0, len(result.Images))
+	failed := 0
+	for i, imageURL := range result.Images {
+		file := d.downloadImage(ctx, result.ID, i+1, imageURL)
+		if file.Error != "" {
+			failed++
+		}
+		files = append(files, file)
+	}
+	result.Files = files
+
+	if d.config.DedupImages {
+		result = collapseDuplicates(result, d.config.ImageDuplicateThreshold)
+		if len(result.DroppedImages) > 0 {
+			log.Printf("Images: Dropped %d near-duplicate images of product %s", len(result.DroppedImages), result.ID)
+		}
+	}
+
+	if d.store != nil {
+		d.store.AddProduct(result.ID, result.Files)
+	}
+
+	if failed > 0 {
+		log.Printf("Images: %d of %d downloads failed for product %s", failed, len(files), result.ID)
+	}
+	return result
+}
+
+// downloadImage fetches one image, retrying up to MaxRetries times. A file
+// left by an earlier run is reused and a partial download is resumed.
+func (d *Downloader) downloadImage(ctx context.Context, id string, index int, imageURL string) models.ImageFile {
+	if d.store != nil {
+		return d.storeImage(ctx, imageURL)
+	}
+
+	file := models.ImageFile{URL: imageURL}
+
+	if existing := d.existingFile(id, index, imageURL); existing != "" {
+		return describe(file, existing, mime.TypeByExtension(filepath.Ext(existing)))
+	}
+
+	// A partial download of another URL, e.g. after the images of the
+	// product were reordered, cannot be resumed
+	partPath := d.Path(id, index, ".part")
+	if !belongsTo(partPath, imageURL) {
+		os.Remove(partPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(partPath), 0755); err != nil {
+		file.Error = err.Error()
+		return file
+	}
+	if err := os.WriteFile(urlFile(partPath), []byte(imageURL), 0644); err != nil {
+		file.Error = err.Error()
+		return file
+	}
+
+	contentType, err := d.fetchWithRetries(ctx, imageURL, partPath)
+	if err != nil {
+		file.Error = err.Error()
+		return file
+	}
+
+	// The old record goes first so that a crash in between never pairs the
+	// new file with the old URL
+	finalPath := d.Path(id, index, Extension(contentType, imageURL))
+	os.Remove(urlFile(finalPath))
+	if err := os.Rename(partPath, finalPath); err != nil {
+		file.Error = err.Error()
+		return file
+	}
+	if err := os.Rename(urlFile(partPath), urlFile(finalPath)); err != nil {
+		file.Error = err.Error()
+		return file
+	}
+	return describe(file, finalPath, contentType)
+}
+
+// fetchWithRetries downloads imageURL into partPath, trying up to MaxRetries
+// times, and returns the content type of the image
+func (d *Downloader) fetchWithRetries(ctx context.Context, imageURL, partPath string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(partPath), 0755); err != nil {
+		return "", err
+	}
+
+	attempts := d.config.MaxRetries
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var contentType string
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(d.config.RetryDelay):
+			}
+		}
+		if contentType, err = d.fetch(ctx, imageURL, partPath); err == nil {
+			return contentType, nil
+		}
+	}
+	return "", err
+}
+
+// fetch downloads imageURL into partPath, continuing from the end of an
+// existing partial file when the server supports range requests. It returns
+// the content type of the image.
+func (d *Downloader) fetch(ctx context.Context, imageURL, partPath string) (string, error) {
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// The server ignored the range; start over
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file does not match the image any more
+		os.Remove(partPath)
+		return "", fmt.Errorf("status %s", resp.Status)
+	default:
+		return "", fmt.Errorf("status %s", resp.Status)
+	}
+
+	out, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	return resp.Header.Get("Content-Type"), nil
+}
+
+// Path returns where the image at index (1-based) of a product is stored,
+// following ImagePathLayout. ext includes the leading dot.
+func (d *Downloader) Path(id string, index int, ext string) string {
+	safeID := unsafeChars.ReplaceAllString(id, "_")
+	if safeID == "" || strings.Trim(safeID, ".") == "" {
+		safeID = "_"
+	}
+	rel := strings.NewReplacer(
+		"{id}", safeID,
+		"{index}", strconv.Itoa(index),
+		"{ext}", strings.TrimPrefix(ext, "."),
+	).Replace(d.config.ImagePathLayout)
+	return filepath.Join(d.config.ImageDir, filepath.FromSlash(rel))
+}
+
+// existingFile returns the completed download of imageURL at index from an
+// earlier run, if there is one. Files downloaded from another URL are not
+// reused.
+func (d *Downloader) existingFile(id string, index int, imageURL string) string {
+	candidates := []string{unknownExtension}
+	for _, ext := range extensions {
+		candidates = append(candidates, ext)
+	}
+	for _, ext := range candidates {
+		path := d.Path(id, index, ext)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && belongsTo(path, imageURL) {
+			return path
+		}
+	}
+	return ""
+}
+
+// urlFile returns the file next to a download that records its URL
+func urlFile(path string) string {
+	return path + ".url"
+}
+
+// belongsTo reports whether the download at path was made from imageURL
+func belongsTo(path, imageURL string) bool {
+	data, err := os.ReadFile(urlFile(path))
+	return err == nil && string(data) == imageURL
+}
+
+// describe fills in the path, size and SHA-256 of a downloaded file
+func describe(file models.ImageFile, path, contentType string) models.ImageFile {
+	f, err := os.Open(path)
+	if err != nil {
+		file.Error = err.Error()
+		return file
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		file.Error = err.Error()
+		return file
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+	file.Path = path
+	file.Size = size
+	file.ContentType = contentType
+	file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return file
+}
+
+// Extension picks a file extension, with its leading dot, for an image from
+// its content type, falling back to the extension in the URL
+func Extension(contentType, imageURL string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if ext, ok := extensions[strings.ToLower(mediaType)]; ok {
+			return ext
+		}
+	}
+
+	if i := strings.IndexAny(imageURL, "?#"); i >= 0 {
+		imageURL = imageURL[:i]
+	}
+	ext := strings.ToLower(path.Ext(imageURL))
+	if ext == ".jpeg" {
+		ext = ".jpg"
+	}
+	for _, known := range extensions {
+		if ext == known {
+			return ext
+		}
+	}
+	return unknownExtension
+}
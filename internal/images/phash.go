@@ -0,0 +1,175 @@
+package images
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+	"strconv"
+
+	"github.com/product-scraper/internal/models"
+)
+
+// DHash computes the difference hash of an image: the image is reduced to
+// 9x8 grayscale cells and each bit records whether a cell is brighter than
+// its right neighbour. Resized or recompressed copies of a photo produce
+// hashes that differ in only a few bits.
+func DHash(img image.Image) uint64 {
+	const width, height = 9, 8
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	var cells [height][width]float64
+	for cy := 0; cy < height; cy++ {
+		y0 := bounds.Min.Y + cy*h/height
+		y1 := bounds.Min.Y + (cy+1)*h/height
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for cx := 0; cx < width; cx++ {
+			x0 := bounds.Min.X + cx*w/width
+			x1 := bounds.Min.X + (cx+1)*w/width
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			// Average the luminance of the source pixels covered by the cell
+			var sum float64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					r, g, b, _ := img.At(x, y).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+				}
+			}
+			cells[cy][cx] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			hash <<= 1
+			if cells[y][x] > cells[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// Distance returns the number of bits in which two hashes differ
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// hashFile decodes a downloaded image and records its dimensions and
+// perceptual hash. Formats the standard library cannot decode are left as
+// they are.
+func hashFile(file *models.ImageFile) bool {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return false
+	}
+	file.Width = img.Bounds().Dx()
+	file.Height = img.Bounds().Dy()
+	file.PHash = fmt.Sprintf("%016x", DHash(img))
+	return true
+}
+
+// collapseDuplicates removes near-duplicate images from a result, keeping the
+// highest resolution copy of each picture in the position of its first
+// occurrence. Dropped copies are listed in DroppedImages.
+func collapseDuplicates(result models.ProductResult, threshold int) models.ProductResult {
+	if len(result.Files) != len(result.Images) {
+		return result
+	}
+
+	type group struct {
+		kept    int
+		hash    uint64
+		members []int
+	}
+
+	var groups []*group
+	groupOf := make(map[int]*group)
+	for i := range result.Files {
+		file := &result.Files[i]
+		if file.Path == "" || !hashFile(file) {
+			continue
+		}
+		hash, _ := strconv.ParseUint(file.PHash, 16, 64)
+
+		var match *group
+		for _, g := range groups {
+			if Distance(g.hash, hash) <= threshold {
+				match = g
+				break
+			}
+		}
+		if match == nil {
+			match = &group{kept: i, hash: hash}
+			groups = append(groups, match)
+		}
+		match.members = append(match.members, i)
+		groupOf[i] = match
+	}
+
+	// Keep the largest image of each group
+	for _, g := range groups {
+		for _, i := range g.members {
+			if larger(result.Files[i], result.Files[g.kept]) {
+				g.kept = i
+			}
+		}
+	}
+
+	images := make([]string, 0, len(result.Images))
+	files := make([]models.ImageFile, 0, len(result.Files))
+	for i := range result.Files {
+		g, ok := groupOf[i]
+		switch {
+		case !ok:
+			// Images that could not be hashed are always kept
+			images = append(images, result.Images[i])
+			files = append(files, result.Files[i])
+		case g.members[0] == i:
+			images = append(images, result.Images[g.kept])
+			files = append(files, result.Files[g.kept])
+		}
+		if ok && i != g.kept {
+			dropped := result.Files[i]
+			kept := result.Files[g.kept]
+			droppedHash, _ := strconv.ParseUint(dropped.PHash, 16, 64)
+			keptHash, _ := strconv.ParseUint(kept.PHash, 16, 64)
+			result.DroppedImages = append(result.DroppedImages, models.DroppedImage{
+				URL:         result.Images[i],
+				DuplicateOf: result.Images[g.kept],
+				Distance:    Distance(droppedHash, keptHash),
+				Width:       dropped.Width,
+				Height:      dropped.Height,
+			})
+		}
+	}
+
+	result.Images = images
+	result.Files = files
+	return result
+}
+
+// larger reports whether a has a higher resolution than b, using the file
+// size to break ties
+func larger(a, b models.ImageFile) bool {
+	if pa, pb := a.Width*a.Height, b.Width*b.Height; pa != pb {
+		return pa > pb
+	}
+	return a.Size > b.Size
+}
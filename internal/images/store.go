@@ -0,0 +1,270 @@
+package images
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/product-scraper/internal/config"
+	"github.com/product-scraper/internal/models"
+)
+
+// manifestSaveInterval is the number of products between manifest saves
+const manifestSaveInterval = 50
+
+// Store is a content-addressed image store. Every distinct image is kept
+// once under ImageDir/objects, named by its SHA-256, and the manifest maps
+// product IDs to the hashes of their images. Images whose URL is already in
+// the manifest are not downloaded again, and a URL shared by products that
+// are downloaded at the same time is fetched once.
+type Store struct {
+	config    *config.Config
+	mutex     sync.Mutex
+	manifest  models.ImageManifest
+	urls      map[string]string
+	inflight  map[string]*storeCall
+	sinceSave int
+}
+
+// storeCall is a download in progress; file is set before done is closed
+type storeCall struct {
+	done chan struct{}
+	file models.ImageFile
+}
+
+// NewStore opens the image store, loading the manifest of earlier runs
+func NewStore(cfg *config.Config) (*Store, error) {
+	s := &Store{
+		config: cfg,
+		manifest: models.ImageManifest{
+			Products: make(map[string][]models.ImageRef),
+			Objects:  make(map[string]models.ImageObject),
+		},
+		urls:     make(map[string]string),
+		inflight: make(map[string]*storeCall),
+	}
+
+	data, err := os.ReadFile(cfg.ImageManifestFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read image manifest: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.manifest); err != nil {
+			return nil, fmt.Errorf("failed to parse image manifest: %v", err)
+		}
+		if s.manifest.Products == nil {
+			s.manifest.Products = make(map[string][]models.ImageRef)
+		}
+		if s.manifest.Objects == nil {
+			s.manifest.Objects = make(map[string]models.ImageObject)
+		}
+		for _, refs := range s.manifest.Products {
+			for _, ref := range refs {
+				s.urls[ref.URL] = ref.SHA256
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// Lookup returns the stored image for a URL, if it was stored before and
+// its file is still there
+func (s *Store) Lookup(imageURL string) (models.ImageFile, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	hash, ok := s.urls[imageURL]
+	if !ok {
+		return models.ImageFile{}, false
+	}
+	object, ok := s.manifest.Objects[hash]
+	if !ok {
+		return models.ImageFile{}, false
+	}
+	if _, err := os.Stat(object.Path); err != nil {
+		return models.ImageFile{}, false
+	}
+	return imageFile(imageURL, hash, object), true
+}
+
+// Add moves a downloaded file into the store. If an identical image is
+// already stored, the download is discarded and the existing object used.
+func (s *Store) Add(imageURL, downloaded, contentType string) (models.ImageFile, error) {
+	file := describe(models.ImageFile{URL: imageURL}, downloaded, contentType)
+	if file.Error != "" {
+		return file, fmt.Errorf("%s", file.Error)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	object, exists := s.manifest.Objects[file.SHA256]
+	if exists {
+		if _, err := os.Stat(object.Path); err != nil {
+			exists = false
+		}
+	}
+
+	if exists {
+		os.Remove(downloaded)
+	} else {
+		object = models.ImageObject{
+			Path:        s.objectPath(file.SHA256, Extension(contentType, imageURL)),
+			Size:        file.Size,
+			ContentType: file.ContentType,
+		}
+		if err := os.MkdirAll(filepath.Dir(object.Path), 0755); err != nil {
+			return file, err
+		}
+		if err := os.Rename(downloaded, object.Path); err != nil {
+			return file, err
+		}
+		s.manifest.Objects[file.SHA256] = object
+	}
+
+	s.urls[imageURL] = file.SHA256
+	return imageFile(imageURL, file.SHA256, object), nil
+}
+
+// AddProduct records the images of a product in the manifest, saving the
+// manifest every few products
+func (s *Store) AddProduct(id string, files []models.ImageFile) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	refs := make([]models.ImageRef, 0, len(files))
+	for _, file := range files {
+		if file.SHA256 != "" {
+			refs = append(refs, models.ImageRef{URL: file.URL, SHA256: file.SHA256})
+		}
+	}
+	s.manifest.Products[id] = refs
+
+	s.sinceSave++
+	if s.sinceSave >= manifestSaveInterval {
+		if err := s.save(); err != nil {
+			log.Printf("Images: %v", err)
+		}
+	}
+}
+
+// Save writes the manifest
+func (s *Store) Save() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.save()
+}
+
+// save writes the manifest atomically. The caller must hold the mutex.
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal image manifest: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.config.ImageManifestFile), 0755); err != nil {
+		return fmt.Errorf("failed to save image manifest: %v", err)
+	}
+	tmpFile := s.config.ImageManifestFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to save image manifest: %v", err)
+	}
+	if err := os.Rename(tmpFile, s.config.ImageManifestFile); err != nil {
+		return fmt.Errorf("failed to save image manifest: %v", err)
+	}
+	s.sinceSave = 0
+	return nil
+}
+
+// objectPath returns where an image with the given hash is stored; objects
+// are spread over subdirectories named after the first two hash characters
+func (s *Store) objectPath(hash, ext string) string {
+	return filepath.Join(s.config.ImageDir, "objects", hash[:2], hash+ext)
+}
+
+// partPath returns where an image is downloaded before its hash is known
+func (s *Store) partPath(imageURL string) string {
+	sum := sha1.Sum([]byte(imageURL))
+	return filepath.Join(s.config.ImageDir, "tmp", hex.EncodeToString(sum[:])+".part")
+}
+
+// join returns the download of imageURL in progress and false, or registers
+// a new one and returns true if the caller should download it
+func (s *Store) join(imageURL string) (*storeCall, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if call, ok := s.inflight[imageURL]; ok {
+		return call, false
+	}
+	call := &storeCall{done: make(chan struct{})}
+	s.inflight[imageURL] = call
+	return call, true
+}
+
+// finish hands the outcome of a download to everyone waiting for it
+func (s *Store) finish(imageURL string, call *storeCall, file models.ImageFile) {
+	s.mutex.Lock()
+	delete(s.inflight, imageURL)
+	s.mutex.Unlock()
+
+	call.file = file
+	close(call.done)
+}
+
+// storeImage downloads an image into the content-addressed store, unless the
+// URL is stored already. A URL that another worker is downloading is waited
+// for instead, as both would write the same partial file.
+func (d *Downloader) storeImage(ctx context.Context, imageURL string) models.ImageFile {
+	if stored, ok := d.store.Lookup(imageURL); ok {
+		return stored
+	}
+
+	call, first := d.store.join(imageURL)
+	if !first {
+		select {
+		case <-call.done:
+			return call.file
+		case <-ctx.Done():
+			return models.ImageFile{URL: imageURL, Error: ctx.Err().Error()}
+		}
+	}
+
+	file := d.fetchIntoStore(ctx, imageURL)
+	d.store.finish(imageURL, call, file)
+	return file
+}
+
+// fetchIntoStore downloads an image and adds it to the store
+func (d *Downloader) fetchIntoStore(ctx context.Context, imageURL string) models.ImageFile {
+	file := models.ImageFile{URL: imageURL}
+	partPath := d.store.partPath(imageURL)
+	contentType, err := d.fetchWithRetries(ctx, imageURL, partPath)
+	if err != nil {
+		file.Error = err.Error()
+		return file
+	}
+
+	stored, err := d.store.Add(imageURL, partPath, contentType)
+	if err != nil {
+		file.Error = err.Error()
+		return file
+	}
+	return stored
+}
+
+func imageFile(imageURL, hash string, object models.ImageObject) models.ImageFile {
+	return models.ImageFile{
+		URL:         imageURL,
+		Path:        object.Path,
+		Size:        object.Size,
+		ContentType: object.ContentType,
+		SHA256:      hash,
+	}
+}
@@ -0,0 +1,129 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/product-scraper/internal/config"
+	"github.com/product-scraper/internal/models"
+)
+
+// Image validation reasons
+const (
+	ReasonRequestFailed = "request_failed"
+	ReasonBadStatus     = "bad_status"
+	ReasonNotImage      = "not_image"
+	ReasonTooSmall      = "too_small"
+)
+
+// headerBytes is how much of an image is fetched to read its dimensions
+const headerBytes = 64 * 1024
+
+// Validator checks the image URLs of results before they are downloaded
+type Validator struct {
+	config *config.Config
+	client *http.Client
+}
+
+// NewValidator creates an image validator
+func NewValidator(cfg *config.Config) (*Validator, error) {
+	switch cfg.ImageValidationMode {
+	case "", "remove", "flag":
+	default:
+		return nil, fmt.Errorf("unknown IMAGE_VALIDATION_MODE %q (available: remove, flag)", cfg.ImageValidationMode)
+	}
+	return &Validator{
+		config: cfg,
+		client: &http.Client{Timeout: cfg.RequestTimeout},
+	}, nil
+}
+
+// Run validates the images of the results read from in, ImageDownloadWorkers
+// products at a time, and passes every result on to out. It closes out when
+// in is closed or the context is done.
+func (v *Validator) Run(ctx context.Context, in <-chan models.ProductResult, out chan<- models.ProductResult) {
+	runStage(ctx, v.config.ImageDownloadWorkers, in, out, v.Validate)
+}
+
+// Validate checks every image of a result and records the problems found in
+// ImageIssues. Unless ImageValidationMode is "flag", the images with problems
+// are removed from the result.
+func (v *Validator) Validate(ctx context.Context, result models.ProductResult) models.ProductResult {
+	remove := v.config.ImageValidationMode != "flag"
+
+	kept := make([]string, 0, len(result.Images))
+	for _, imageURL := range result.Images {
+		issue := v.check(ctx, imageURL)
+		if issue == nil {
+			kept = append(kept, imageURL)
+			continue
+		}
+		issue.Removed = remove
+		result.ImageIssues = append(result.ImageIssues, *issue)
+		if !remove {
+			kept = append(kept, imageURL)
+		}
+	}
+	result.Images = kept
+
+	if len(result.ImageIssues) > 0 {
+		log.Printf("Images: %d images of product %s failed validation", len(result.ImageIssues), result.ID)
+	}
+	return result
+}
+
+// check fetches the start of an image and reports what is wrong with it, or
+// nil if it is fine. Only the first bytes are requested, enough for the
+// status, content type and dimensions.
+func (v *Validator) check(ctx context.Context, imageURL string) *models.ImageIssue {
+	issue := func(reason, detail string) *models.ImageIssue {
+		return &models.ImageIssue{URL: imageURL, Reason: reason, Detail: detail}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return issue(ReasonRequestFailed, err.Error())
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", headerBytes-1))
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return issue(ReasonRequestFailed, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return issue(ReasonBadStatus, resp.Status)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && !strings.HasPrefix(mediaType, "image/") {
+		return issue(ReasonNotImage, "content type "+mediaType)
+	}
+
+	head, err := io.ReadAll(io.LimitReader(resp.Body, headerBytes))
+	if err != nil {
+		return issue(ReasonRequestFailed, err.Error())
+	}
+
+	// Formats the standard library cannot read, such as WebP, are accepted
+	// without a size check
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(head))
+	if err != nil {
+		return nil
+	}
+	if cfg.Width < v.config.ImageMinWidth || cfg.Height < v.config.ImageMinHeight {
+		found := issue(ReasonTooSmall, fmt.Sprintf("%s %dx%d, minimum %dx%d",
+			format, cfg.Width, cfg.Height, v.config.ImageMinWidth, v.config.ImageMinHeight))
+		found.Width, found.Height = cfg.Width, cfg.Height
+		return found
+	}
+	return nil
+}
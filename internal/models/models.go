@@ -6,27 +6,155 @@ import "time"
 type Product struct {
 	ID   string `json:"id"`
 	Link string `json:"link"`
+	// Metadata holds the input columns that are not mapped to ID or Link
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Sheet and Row locate the product in the input file (Row is 1-based)
+	Sheet string `json:"sheet,omitempty"`
+	Row   int    `json:"row,omitempty"`
 }
 
 // ProductResult represents the result of scraping a product
 type ProductResult struct {
-	ID      string   `json:"id"`
-	Images  []string `json:"images"`
-	Success bool     `json:"success"`
-	Error   string   `json:"error,omitempty"`
+	ID     string   `json:"id"`
+	Images []string `json:"images"`
+	// URL is the product link from the input; FinalURL is where the browser
+	// ended up after redirects
+	URL      string            `json:"url,omitempty"`
+	FinalURL string            `json:"final_url,omitempty"`
+	Success  bool              `json:"success"`
+	Error    string            `json:"error,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Attempts []Attempt         `json:"attempts,omitempty"`
+	// Files holds the downloaded copy of each image, in the order of Images
+	Files []ImageFile `json:"files,omitempty"`
+	// DroppedImages lists images removed as near-duplicates of a kept image
+	DroppedImages []DroppedImage `json:"dropped_images,omitempty"`
+	// ImageIssues lists images that failed validation
+	ImageIssues []ImageIssue `json:"image_issues,omitempty"`
 }
 
-// FailedURL represents a failed scraping attempt
+// ImageIssue is an image URL that failed validation. Removed reports whether
+// the image was taken out of the result or only flagged.
+type ImageIssue struct {
+	URL     string `json:"url"`
+	Reason  string `json:"reason"`
+	Detail  string `json:"detail,omitempty"`
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+	Removed bool   `json:"removed"`
+}
+
+// DroppedImage is an image removed from a result because it shows the same
+// picture as another image of the product
+type DroppedImage struct {
+	URL         string `json:"url"`
+	DuplicateOf string `json:"duplicate_of"`
+	Distance    int    `json:"distance"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+}
+
+// ImageFile is a downloaded image
+type ImageFile struct {
+	URL         string `json:"url"`
+	Path        string `json:"path,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	// Width, Height and the perceptual hash are set for decodable images
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	PHash  string `json:"phash,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Attempt records a single try at scraping a product
+type Attempt struct {
+	Timestamp  time.Time `json:"timestamp"`
+	DurationMS int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+	WorkerID   int       `json:"worker_id"`
+}
+
+// ImageManifest maps products to the images in the content-addressed image
+// store. Objects are keyed by SHA-256.
+type ImageManifest struct {
+	Products map[string][]ImageRef  `json:"products"`
+	Objects  map[string]ImageObject `json:"objects"`
+}
+
+// ImageRef references a stored image by hash and original URL
+type ImageRef struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+}
+
+// ImageObject is a file in the content-addressed image store
+type ImageObject struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// FailedURL represents a failed scraping attempt. Attempts counts every try
+// across runs and History lists each of them with its error.
 type FailedURL struct {
 	ID        string    `json:"id"`
 	URL       string    `json:"url"`
 	Error     string    `json:"error"`
 	Timestamp time.Time `json:"timestamp"`
 	Attempts  int       `json:"attempts"`
+	History   []Attempt `json:"history,omitempty"`
 }
 
-// ResumeData represents the state for resuming interrupted scraping
+// ResumeData represents the state for resuming interrupted scraping. Workers
+// finish out of order, so completion is tracked per product ID rather than by
+// LastProcessedIndex alone.
 type ResumeData struct {
-	LastProcessedIndex int       `json:"last_processed_index"`
-	Timestamp          time.Time `json:"timestamp"`
+	LastProcessedIndex int             `json:"last_processed_index"`
+	CompletedIDs       []string        `json:"completed_ids"`
+	Results            []ProductResult `json:"results"`
+	FailedURLs         []FailedURL     `json:"failed_urls"`
+	Timestamp          time.Time       `json:"timestamp"`
+}
+
+// JournalEntry is one line of the append-only results journal; exactly one of
+// Result and Failure is set. A later entry for the same product ID replaces
+// an earlier one.
+type JournalEntry struct {
+	Result  *ProductResult `json:"result,omitempty"`
+	Failure *FailedURL     `json:"failure,omitempty"`
+}
+
+// ValidationIssue describes a problem found with a single input row
+type ValidationIssue struct {
+	Sheet  string `json:"sheet,omitempty"`
+	Row    int    `json:"row"`
+	ID     string `json:"id,omitempty"`
+	Link   string `json:"link,omitempty"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ValidationReport summarises the input rows that were skipped or look suspicious
+type ValidationReport struct {
+	InputFile  string            `json:"input_file"`
+	Timestamp  time.Time         `json:"timestamp"`
+	TotalRows  int               `json:"total_rows"`
+	ValidRows  int               `json:"valid_rows"`
+	Skipped    []ValidationIssue `json:"skipped"`
+	Suspicious []ValidationIssue `json:"suspicious"`
+}
+
+// DedupRecord describes an input product that was collapsed into another one
+// before scraping
+type DedupRecord struct {
+	ID           string `json:"id"`
+	Link         string `json:"link"`
+	Sheet        string `json:"sheet,omitempty"`
+	Row          int    `json:"row,omitempty"`
+	CanonicalURL string `json:"canonical_url"`
+	KeptID       string `json:"kept_id"`
+	KeptLink     string `json:"kept_link"`
+	Reason       string `json:"reason"`
 }
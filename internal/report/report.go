@@ -0,0 +1,285 @@
+// Package report builds the QA workbook reviewers use to check the scraped
+// images without opening every URL.
+package report
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/product-scraper/internal/config"
+	"github.com/product-scraper/internal/images"
+	"github.com/product-scraper/internal/models"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	sheetName = "Report"
+	// Thumbnail cells are about 100 pixels square
+	thumbnailRowHeight = 78
+	thumbnailColWidth  = 15
+)
+
+// thumbnailExtensions are the image formats that can be shrunk into thumbnails
+var thumbnailExtensions = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true,
+}
+
+// Generator writes the QA report
+type Generator struct {
+	config *config.Config
+	client *http.Client
+}
+
+// reportRow is one product in the report
+type reportRow struct {
+	id     string
+	link   string
+	status string
+	err    string
+	images []string
+}
+
+// New creates a report generator
+func New(cfg *config.Config) *Generator {
+	return &Generator{
+		config: cfg,
+		client: &http.Client{Timeout: cfg.RequestTimeout},
+	}
+}
+
+// Write creates the report at ReportFile with one row per product: ID, link,
+// status, error, image count and the first ReportThumbnails images embedded
+// as thumbnails. Images already downloaded by the image download stage are
+// used as they are; others are fetched into ReportImageDir. Images that
+// cannot be fetched or embedded are written as links instead.
+func (g *Generator) Write(ctx context.Context, results []models.ProductResult, failedURLs []models.FailedURL) error {
+	rows := make([]reportRow, 0, len(results)+len(failedURLs))
+	thumbnails := make(map[string]string)
+	for _, result := range results {
+		rows = append(rows, reportRow{id: result.ID, link: result.URL, status: "success", images: result.Images})
+		for _, file := range result.Files {
+			if file.Path != "" && thumbnailExtensions[filepath.Ext(file.Path)] {
+				thumbnails[file.URL] = file.Path
+			}
+		}
+	}
+	for _, failed := range failedURLs {
+		rows = append(rows, reportRow{id: failed.ID, link: failed.URL, status: "failed", err: failed.Error})
+	}
+
+	for imageURL, file := range g.fetchThumbnails(ctx, rows, thumbnails) {
+		thumbnails[imageURL] = file
+	}
+
+	f := excelize.NewFile()
+	defer f.Close()
+	f.SetSheetName("Sheet1", sheetName)
+
+	headers := []interface{}{"ID", "Link", "Status", "Error", "Images"}
+	for i := 1; i <= g.config.ReportThumbnails; i++ {
+		headers = append(headers, fmt.Sprintf("Image %d", i))
+	}
+	if err := f.SetSheetRow(sheetName, "A1", &headers); err != nil {
+		return fmt.Errorf("failed to write report header: %v", err)
+	}
+	if style, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}}); err == nil {
+		last, _ := excelize.CoordinatesToCellName(len(headers), 1)
+		f.SetCellStyle(sheetName, "A1", last, style)
+	}
+	f.SetPanes(sheetName, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+	f.SetColWidth(sheetName, "A", "A", 15)
+	f.SetColWidth(sheetName, "B", "B", 40)
+	f.SetColWidth(sheetName, "D", "D", 30)
+	if g.config.ReportThumbnails > 0 {
+		first, _ := excelize.ColumnNumberToName(6)
+		last, _ := excelize.ColumnNumberToName(5 + g.config.ReportThumbnails)
+		f.SetColWidth(sheetName, first, last, thumbnailColWidth)
+	}
+
+	// Thumbnails are shrunk once per image, however many products share it
+	pictures := make(map[string]*excelize.Picture)
+	for i, row := range rows {
+		rowNum := i + 2
+		cell, _ := excelize.CoordinatesToCellName(1, rowNum)
+		values := []interface{}{row.id, row.link, row.status, row.err, len(row.images)}
+		if err := f.SetSheetRow(sheetName, cell, &values); err != nil {
+			return fmt.Errorf("failed to write report row %d: %v", rowNum, err)
+		}
+		if row.link != "" {
+			linkCell, _ := excelize.CoordinatesToCellName(2, rowNum)
+			f.SetCellHyperLink(sheetName, linkCell, row.link, "External")
+		}
+
+		count := len(row.images)
+		if count > g.config.ReportThumbnails {
+			count = g.config.ReportThumbnails
+		}
+		if count > 0 {
+			f.SetRowHeight(sheetName, rowNum, thumbnailRowHeight)
+		}
+		for j := 0; j < count; j++ {
+			imageURL := row.images[j]
+			cell, _ := excelize.CoordinatesToCellName(6+j, rowNum)
+			if file := thumbnails[imageURL]; file != "" {
+				picture, ok := pictures[imageURL]
+				if !ok {
+					picture = thumbnailPicture(imageURL, file)
+					pictures[imageURL] = picture
+				}
+				if picture != nil {
+					err := f.AddPictureFromBytes(sheetName, cell, picture)
+					if err == nil {
+						continue
+					}
+					log.Printf("Report: Failed to embed %s: %v", imageURL, err)
+				}
+			}
+			f.SetCellValue(sheetName, cell, imageURL)
+			f.SetCellHyperLink(sheetName, cell, imageURL, "External")
+		}
+	}
+
+	if err := f.SaveAs(g.config.ReportFile); err != nil {
+		return fmt.Errorf("failed to save report: %v", err)
+	}
+
+	log.Printf("Report with %d products saved to %s", len(rows), g.config.ReportFile)
+	return nil
+}
+
+// thumbnailPicture shrinks an image file into a picture linking to the full
+// image, or returns nil if the file cannot be decoded
+func thumbnailPicture(imageURL, file string) *excelize.Picture {
+	data, ext, err := makeThumbnail(file)
+	if err != nil {
+		log.Printf("Report: Failed to create thumbnail for %s: %v", imageURL, err)
+		return nil
+	}
+	return &excelize.Picture{
+		Extension: ext,
+		File:      data,
+		Format: &excelize.GraphicOptions{
+			AutoFit:         true,
+			LockAspectRatio: true,
+			Hyperlink:       imageURL,
+			HyperlinkType:   "External",
+		},
+	}
+}
+
+// fetchThumbnails downloads the images shown in the report that are not in
+// local, WorkerCount at a time, and returns the local file of each image URL
+// that could be fetched
+func (g *Generator) fetchThumbnails(ctx context.Context, rows []reportRow, local map[string]string) map[string]string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		for i, imageURL := range row.images {
+			if i == g.config.ReportThumbnails {
+				break
+			}
+			if !seen[imageURL] && local[imageURL] == "" {
+				seen[imageURL] = true
+				urls = append(urls, imageURL)
+			}
+		}
+	}
+
+	files := make(map[string]string)
+	if len(urls) == 0 {
+		return files
+	}
+	if err := os.MkdirAll(g.config.ReportImageDir, 0755); err != nil {
+		log.Printf("Report: Failed to create image directory: %v", err)
+		return files
+	}
+
+	workers := g.config.WorkerCount
+	if workers < 1 {
+		workers = 1
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for imageURL := range queue {
+				file, err := g.fetchImage(ctx, imageURL)
+				if err != nil {
+					log.Printf("Report: Failed to download %s: %v", imageURL, err)
+					continue
+				}
+				mutex.Lock()
+				files[imageURL] = file
+				mutex.Unlock()
+			}
+		}()
+	}
+	for _, imageURL := range urls {
+		queue <- imageURL
+	}
+	close(queue)
+	wg.Wait()
+
+	return files
+}
+
+// fetchImage downloads an image into ReportImageDir, named after the hash of
+// its URL so that later reports reuse it
+func (g *Generator) fetchImage(ctx context.Context, imageURL string) (string, error) {
+	sum := sha1.Sum([]byte(imageURL))
+	base := filepath.Join(g.config.ReportImageDir, hex.EncodeToString(sum[:]))
+
+	// Reuse a previous download
+	for ext := range thumbnailExtensions {
+		if _, err := os.Stat(base + ext); err == nil {
+			return base + ext, nil
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %s", resp.Status)
+	}
+
+	ext := images.Extension(resp.Header.Get("Content-Type"), imageURL)
+	if !thumbnailExtensions[ext] {
+		return "", fmt.Errorf("unsupported image type %q", resp.Header.Get("Content-Type"))
+	}
+
+	file := base + ext
+	tmpFile := file + ".part"
+	out, err := os.Create(tmpFile)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		os.Remove(tmpFile)
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpFile)
+		return "", err
+	}
+	return file, os.Rename(tmpFile, file)
+}
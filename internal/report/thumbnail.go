@@ -0,0 +1,92 @@
+package report
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// thumbnailSize is the longest side of an embedded thumbnail in pixels,
+	// about the size of a thumbnail cell
+	thumbnailSize = 100
+	// thumbnailSamples is the number of samples per axis averaged into each
+	// thumbnail pixel
+	thumbnailSamples = 4
+)
+
+// makeThumbnail decodes an image file and shrinks it to fit thumbnailSize,
+// keeping its aspect ratio. It returns the encoded thumbnail and its
+// extension: JPEG for JPEG sources and PNG otherwise, so transparency is kept.
+func makeThumbnail(file string) ([]byte, string, error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return nil, "", err
+	}
+	defer in.Close()
+
+	src, _, err := image.Decode(in)
+	if err != nil {
+		return nil, "", err
+	}
+	thumb := shrink(src, thumbnailSize)
+
+	var buf bytes.Buffer
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".jpg", ".jpeg":
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+		return buf.Bytes(), ".jpg", err
+	default:
+		err = png.Encode(&buf, thumb)
+		return buf.Bytes(), ".png", err
+	}
+}
+
+// shrink scales an image down so that its longest side is at most size
+// pixels. Each pixel is the average of a grid of samples from the area it
+// covers, which keeps the cost independent of the source resolution.
+func shrink(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW <= size && srcH <= size {
+		return src
+	}
+
+	w, h := size, srcH*size/srcW
+	if srcH > srcW {
+		w, h = srcW*size/srcH, size
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var r, g, b, a uint32
+			for sy := 0; sy < thumbnailSamples; sy++ {
+				py := bounds.Min.Y + ((y*thumbnailSamples+sy)*srcH+srcH/2)/(h*thumbnailSamples)
+				for sx := 0; sx < thumbnailSamples; sx++ {
+					px := bounds.Min.X + ((x*thumbnailSamples+sx)*srcW+srcW/2)/(w*thumbnailSamples)
+					c := color.NRGBAModel.Convert(src.At(px, py)).(color.NRGBA)
+					r += uint32(c.R)
+					g += uint32(c.G)
+					b += uint32(c.B)
+					a += uint32(c.A)
+				}
+			}
+			n := uint32(thumbnailSamples * thumbnailSamples)
+			dst.SetNRGBA(x, y, color.NRGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: uint8(a / n)})
+		}
+	}
+	return dst
+}
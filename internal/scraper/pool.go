@@ -0,0 +1,304 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/product-scraper/internal/config"
+)
+
+// healthCheckTimeout bounds the check made on a browser after a page failed
+const healthCheckTimeout = 5 * time.Second
+
+// Pool shares a few long-lived Chrome processes between the workers. Every
+// page is opened in a new incognito browser context, so products never see
+// each other's cookies, storage or cache, without paying for a Chrome launch
+// per page.
+type Pool struct {
+	config *config.Config
+	// mutex guards the pool and browser state below; it is never held while
+	// talking to Chrome
+	mutex    sync.Mutex
+	slots    []*poolSlot
+	next     int
+	launches int
+	closed   bool
+}
+
+// poolSlot holds one of the pool's browsers. Its mutex is held while the
+// browser is checked or started, so that only the workers using this slot
+// wait for it.
+type poolSlot struct {
+	mutex   sync.Mutex
+	browser *pooledBrowser
+}
+
+// pooledBrowser is a running Chrome process and the tabs open in it. A
+// browser is suspect after a page in it failed, and is checked before its
+// next page.
+type pooledBrowser struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	pages   int
+	open    int
+	retired bool
+	suspect bool
+}
+
+// NewPool creates a browser pool. Browsers are started on first use.
+func NewPool(cfg *config.Config) *Pool {
+	size := cfg.BrowserPoolSize
+	if size < 1 {
+		size = 1
+	}
+	slots := make([]*poolSlot, size)
+	for i := range slots {
+		slots[i] = &poolSlot{}
+	}
+	return &Pool{
+		config: cfg,
+		slots:  slots,
+	}
+}
+
+// Tab opens a fresh incognito tab in one of the pooled browsers. A browser
+// that has crashed, or stopped responding after a failed page, is replaced,
+// as is one that has served BrowserRecyclePages pages. The returned function
+// closes the tab and must be called when the page is done, telling whether
+// the page failed.
+func (p *Pool) Tab() (context.Context, func(failed bool), error) {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil, nil, fmt.Errorf("browser pool is closed")
+	}
+	index := p.next
+	p.next = (p.next + 1) % len(p.slots)
+	p.mutex.Unlock()
+
+	b, err := p.acquire(index)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tabCtx, tabCancel := chromedp.NewContext(b.ctx, chromedp.WithNewBrowserContext())
+	var once sync.Once
+	release := func(failed bool) {
+		once.Do(func() {
+			tabCancel()
+			p.mutex.Lock()
+			defer p.mutex.Unlock()
+			b.open--
+			if failed {
+				b.suspect = true
+			}
+			if b.retired && b.open == 0 {
+				b.close()
+			}
+		})
+	}
+	return tabCtx, release, nil
+}
+
+// acquire returns the browser of a slot with a page reserved in it, checking,
+// starting or replacing the browser as needed
+func (p *Pool) acquire(index int) (*pooledBrowser, error) {
+	slot := p.slots[index]
+	slot.mutex.Lock()
+	defer slot.mutex.Unlock()
+
+	p.mutex.Lock()
+	b := slot.browser
+	check := b != nil && !b.retired && b.suspect
+	p.mutex.Unlock()
+
+	if check && !b.healthy() {
+		log.Printf("Browser pool: browser %d is not responding, restarting it", index+1)
+		p.mutex.Lock()
+		p.retire(b)
+		p.mutex.Unlock()
+	}
+
+	p.mutex.Lock()
+	if b != nil && !b.retired && b.ctx.Err() != nil {
+		log.Printf("Browser pool: browser %d has crashed, restarting it", index+1)
+		p.retire(b)
+	}
+	launch := b == nil || b.retired
+	p.mutex.Unlock()
+
+	if launch {
+		started, err := p.launch()
+		if err != nil {
+			return nil, err
+		}
+		b = started
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		if launch {
+			b.close()
+		}
+		return nil, fmt.Errorf("browser pool is closed")
+	}
+	slot.browser = b
+	b.suspect = false
+	b.pages++
+	b.open++
+	if limit := p.config.BrowserRecyclePages; limit > 0 && b.pages >= limit {
+		// Let the open tabs finish; the next page gets a new browser
+		log.Printf("Browser pool: browser %d served %d pages, restarting it", index+1, b.pages)
+		b.retired = true
+	}
+	return b, nil
+}
+
+// Launches returns how many browsers the pool has started
+func (p *Pool) Launches() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.launches
+}
+
+// Close shuts down every browser in the pool
+func (p *Pool) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closed = true
+	for _, slot := range p.slots {
+		if slot.browser != nil {
+			slot.browser.close()
+			slot.browser = nil
+		}
+	}
+}
+
+// launch starts a browser. The caller must not hold the mutex.
+func (p *Pool) launch() (*pooledBrowser, error) {
+	// Browsers outlive any single request, so they are detached from the
+	// contexts of the workers
+	browserCtx, cancel, err := NewBrowser(context.Background(), p.config, chromedp.WithErrorf(logChromedpError))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start browser: %v", err)
+	}
+
+	p.mutex.Lock()
+	p.launches++
+	p.mutex.Unlock()
+	return &pooledBrowser{ctx: browserCtx, cancel: cancel}, nil
+}
+
+// retire takes a browser out of service, closing it once its open tabs are
+// done. The caller must hold the mutex.
+func (p *Pool) retire(b *pooledBrowser) {
+	b.retired = true
+	if b.open == 0 {
+		b.close()
+	}
+}
+
+// healthy reports whether the browser still answers
+func (b *pooledBrowser) healthy() bool {
+	if b.ctx.Err() != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(b.ctx, healthCheckTimeout)
+	defer cancel()
+	var alive bool
+	return chromedp.Run(ctx, chromedp.Evaluate(`true`, &alive)) == nil && alive
+}
+
+// close shuts the browser down; closing it again is harmless
+func (b *pooledBrowser) close() {
+	b.cancel()
+}
+
+// NewBrowser launches Chrome with AllocatorOptions and returns its context
+// and a function that shuts it down. A browser that has not started within
+// BrowserStartTimeout is killed.
+func NewBrowser(parent context.Context, cfg *config.Config, opts ...chromedp.ContextOption) (context.Context, context.CancelFunc, error) {
+	// A slow start is stopped by cancelling the parent of the allocator;
+	// cancelling the allocator itself would wait on the launch in progress
+	startCtx, stop := context.WithCancel(parent)
+	allocCtx, allocCancel := chromedp.NewExecAllocator(startCtx, AllocatorOptions(cfg)...)
+	browserCtx, cancel := chromedp.NewContext(allocCtx, opts...)
+	shutdown := func() {
+		cancel()
+		allocCancel()
+		stop()
+	}
+
+	// The first Run has no timeout of its own, as cancelling its context
+	// would close the browser
+	var timer *time.Timer
+	if cfg.BrowserStartTimeout > 0 {
+		timer = time.AfterFunc(cfg.BrowserStartTimeout, stop)
+	}
+	err := chromedp.Run(browserCtx)
+	if timer != nil && !timer.Stop() {
+		err = fmt.Errorf("browser did not start within %v", cfg.BrowserStartTimeout)
+	}
+	if err != nil {
+		shutdown()
+		return nil, nil, err
+	}
+	return browserCtx, shutdown, nil
+}
+
+// AllocatorOptions returns the options Chrome is launched with: chromedp's
+// defaults, unless DisableDefaultBrowserFlags is set, overridden by
+// BrowserFlags.
+func AllocatorOptions(cfg *config.Config) []chromedp.ExecAllocatorOption {
+	var opts []chromedp.ExecAllocatorOption
+	if !cfg.DisableDefaultBrowserFlags {
+		opts = append(opts, chromedp.DefaultExecAllocatorOptions[:]...)
+	}
+	for _, flag := range cfg.BrowserFlags {
+		name, value := parseFlag(flag)
+		if name != "" {
+			opts = append(opts, chromedp.Flag(name, value))
+		}
+	}
+	if cfg.ChromePath != "" {
+		opts = append(opts, chromedp.ExecPath(cfg.ChromePath))
+	}
+	// NewBrowser bounds the startup; chromedp's own timeout is kept out of
+	// its way, as chromedp v0.9.5 races with the launch when it fires
+	if cfg.BrowserStartTimeout > 0 {
+		opts = append(opts, chromedp.WSURLReadTimeout(cfg.BrowserStartTimeout+time.Minute))
+	}
+	return opts
+}
+
+// parseFlag splits a switch such as "--window-size=1920,1080" into its name
+// and value. A switch without a value is true, and "true" or "false" values
+// are booleans; chromedp leaves out switches that are false.
+func parseFlag(flag string) (string, interface{}) {
+	name, value, found := strings.Cut(strings.TrimLeft(flag, "-"), "=")
+	if !found {
+		return name, true
+	}
+	switch strings.ToLower(value) {
+	case "true":
+		return name, true
+	case "false":
+		return name, false
+	}
+	return name, value
+}
+
+// logChromedpError logs chromedp errors, filtering out benign messages
+func logChromedpError(s string, i ...interface{}) {
+	msg := fmt.Sprintf(s, i...)
+	if strings.Contains(msg, "could not unmarshal event") || strings.Contains(msg, "unknown ClientNavigationReason value") {
+		return
+	}
+	log.Printf("Chromedp Error: %s", msg)
+}
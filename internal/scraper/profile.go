@@ -0,0 +1,152 @@
+package scraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile describes where the product images are on the pages of a site
+type Profile struct {
+	Name string `json:"name" yaml:"name"`
+	// Hosts are glob patterns matched against the host of a product link,
+	// e.g. "www.example.com" or "*.example.com"
+	Hosts []string `json:"hosts" yaml:"hosts"`
+	// WaitSelector is a CSS selector that must be visible before images are
+	// collected; without it the page body is awaited
+	WaitSelector string `json:"wait_selector,omitempty" yaml:"wait_selector,omitempty"`
+	// ImageSelectors are CSS selectors for the elements holding image URLs in
+	// Attribute ("src" by default)
+	ImageSelectors []string `json:"image_selectors,omitempty" yaml:"image_selectors,omitempty"`
+	Attribute      string   `json:"attribute,omitempty" yaml:"attribute,omitempty"`
+	// Script, when set, is evaluated instead of the selectors and must return
+	// an array of image URLs
+	Script string `json:"script,omitempty" yaml:"script,omitempty"`
+	// Only https:// URLs are kept unless AllowHTTP is set
+	AllowHTTP bool `json:"allow_http,omitempty" yaml:"allow_http,omitempty"`
+}
+
+// DefaultProfile is used for links no site profile matches
+func DefaultProfile() Profile {
+	return Profile{
+		Name:           "default",
+		WaitSelector:   "#js-product-images-container",
+		ImageSelectors: []string{`[data-slide-id="zoom"]`},
+		Attribute:      "href",
+	}
+}
+
+// LoadProfiles reads every .yaml, .yml and .json file in dir as a site
+// profile, in file name order. An empty dir means no profiles.
+func LoadProfiles(dir string) ([]Profile, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read site profiles: %v", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var profiles []Profile
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		profile, err := loadProfile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// loadProfile reads and checks a single profile file
+func loadProfile(filename string) (Profile, error) {
+	var profile Profile
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return profile, fmt.Errorf("failed to read site profile %s: %v", filename, err)
+	}
+
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		err = json.Unmarshal(data, &profile)
+	} else {
+		err = yaml.Unmarshal(data, &profile)
+	}
+	if err != nil {
+		return profile, fmt.Errorf("failed to parse site profile %s: %v", filename, err)
+	}
+
+	if profile.Name == "" {
+		profile.Name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+	if profile.Attribute == "" {
+		profile.Attribute = "src"
+	}
+	if len(profile.Hosts) == 0 {
+		return profile, fmt.Errorf("site profile %s has no hosts", filename)
+	}
+	for _, pattern := range profile.Hosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return profile, fmt.Errorf("site profile %s has an invalid host pattern %q: %v", filename, pattern, err)
+		}
+	}
+	if len(profile.ImageSelectors) == 0 && profile.Script == "" {
+		return profile, fmt.Errorf("site profile %s needs image_selectors or a script", filename)
+	}
+	return profile, nil
+}
+
+// Matches reports whether the profile applies to a product link
+func (p Profile) Matches(link string) bool {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, pattern := range p.Hosts {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// extractScript returns the JavaScript that collects the image URLs.
+// Attribute values are resolved against the page, so relative and
+// protocol-relative URLs come back absolute.
+func (p Profile) extractScript() string {
+	if p.Script != "" {
+		return p.Script
+	}
+	selectors, _ := json.Marshal(p.ImageSelectors)
+	attribute, _ := json.Marshal(p.Attribute)
+	return fmt.Sprintf(`
+            %s.flatMap(selector => Array.from(document.querySelectorAll(selector)))
+                .map(el => el.getAttribute(%s))
+                .filter(v => v)
+                .map(v => {
+                    try {
+                        return new URL(v, document.baseURI).href;
+                    } catch (e) {
+                        return v;
+                    }
+                })
+        `, selectors, attribute)
+}
+
+// keep reports whether an extracted image URL is used
+func (p Profile) keep(imageURL string) bool {
+	return strings.HasPrefix(imageURL, "https://") || (p.AllowHTTP && strings.HasPrefix(imageURL, "http://"))
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"strings" // Added for message filtering
 	"time"
 
 	"github.com/chromedp/chromedp"
@@ -13,13 +12,36 @@ import (
 )
 
 type Scraper struct {
-	config *config.Config
+	config   *config.Config
+	pool     *Pool
+	profiles []Profile
 }
 
-func New(cfg *config.Config) *Scraper {
+// New creates a scraper with the site profiles in SiteProfilesDir
+func New(cfg *config.Config) (*Scraper, error) {
+	profiles, err := LoadProfiles(cfg.SiteProfilesDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(profiles) > 0 {
+		log.Printf("Loaded %d site profiles from %s", len(profiles), cfg.SiteProfilesDir)
+	}
 	return &Scraper{
-		config: cfg,
+		config:   cfg,
+		pool:     NewPool(cfg),
+		profiles: profiles,
+	}, nil
+}
+
+// ProfileFor returns the first site profile matching a product link, or the
+// default profile
+func (s *Scraper) ProfileFor(link string) Profile {
+	for _, profile := range s.profiles {
+		if profile.Matches(link) {
+			return profile
+		}
 	}
+	return DefaultProfile()
 }
 
 // Worker processes products from the productChan and sends results to resultChan
@@ -53,9 +75,11 @@ func (s *Scraper) Worker(ctx context.Context, workerID int, productChan <-chan m
 // ScrapeProduct scrapes a single product and returns the result
 func (s *Scraper) ScrapeProduct(ctx context.Context, workerID int, product models.Product) models.ProductResult {
 	result := models.ProductResult{
-		ID:      product.ID,
-		Images:  make([]string, 0),
-		Success: false,
+		ID:       product.ID,
+		Images:   make([]string, 0),
+		URL:      product.Link,
+		Success:  false,
+		Metadata: product.Metadata,
 	}
 
 	// Implement retry logic
@@ -76,11 +100,23 @@ func (s *Scraper) ScrapeProduct(ctx context.Context, workerID int, product model
 			time.Sleep(s.config.RetryDelay)
 		}
 
-		// Create a completely fresh browser context for each request like the working script
-		images, err := s.scrapeWithFreshContext(ctx, product.Link)
+		// Every attempt gets a fresh incognito tab in a pooled browser
+		started := time.Now()
+		images, finalURL, err := s.scrapeWithFreshContext(ctx, product.Link)
+
+		record := models.Attempt{
+			Timestamp:  started,
+			DurationMS: time.Since(started).Milliseconds(),
+			WorkerID:   workerID,
+		}
+		if err != nil {
+			record.Error = err.Error()
+		}
+		result.Attempts = append(result.Attempts, record)
 
 		if err == nil {
 			result.Images = images
+			result.FinalURL = finalURL
 			result.Success = true
 			return result
 		}
@@ -97,71 +133,71 @@ func (s *Scraper) ScrapeProduct(ctx context.Context, workerID int, product model
 	return result
 }
 
-// scrapeWithFreshContext creates a fresh browser context for each request
-func (s *Scraper) scrapeWithFreshContext(parentCtx context.Context, url string) ([]string, error) {
+// scrapeWithFreshContext opens the page in a fresh incognito tab and returns
+// the image URLs together with the page URL after any redirects
+func (s *Scraper) scrapeWithFreshContext(parentCtx context.Context, url string) ([]string, string, error) {
 	// Check if parent context is already canceled before starting
 	select {
 	case <-parentCtx.Done():
-		return nil, fmt.Errorf("parent context canceled before starting scrape")
+		return nil, "", fmt.Errorf("parent context canceled before starting scrape")
 	default:
 	}
 
-	// Create a completely fresh browser context using background context
-	// This detaches browser operations from the parent context hierarchy
-	// exactly like the working single script approach
-
-	// Custom error logger to filter out specific benign messages
-	customErrorFunc := func(s string, i ...interface{}) {
-		msg := fmt.Sprintf(s, i...)
-		if strings.Contains(msg, "could not unmarshal event") || strings.Contains(msg, "unknown ClientNavigationReason value") {
-			// Optionally log these to a debug log or just ignore
-			// log.Printf("Chromedp Info (suppressed): %s", msg)
-			return
-		}
-		log.Printf("Chromedp Error: %s", msg)
+	// The tab is detached from the parent context so that an interrupt lets
+	// the page in progress finish
+	browserCtx, release, err := s.pool.Tab()
+	if err != nil {
+		return nil, "", err
 	}
+	// A failed page gets its browser checked before the next page
+	defer func() { release(err != nil) }()
 
-	allocOpts := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.Flag("headless", true), // Ensure headless is explicitly set
-		// Add other allocator options if needed
-	)
-
-	allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), allocOpts...)
-	defer allocCancel()
+	timeoutCtx, timeoutCancel := context.WithTimeout(browserCtx, s.config.RequestTimeout)
+	defer timeoutCancel()
 
-	// Pass the custom error logger to the context
-	browserCtx, cancel := chromedp.NewContext(allocCtx, chromedp.WithErrorf(customErrorFunc))
-	defer cancel()
+	profile := s.ProfileFor(url)
 
-	// Use a shorter timeout like the working script (15s instead of 45s)
-	requestTimeout := 15 * time.Second
-	timeoutCtx, timeoutCancel := context.WithTimeout(browserCtx, requestTimeout)
-	defer timeoutCancel()
+	// Wait for the product images container to be visible
+	wait := chromedp.WaitReady("body", chromedp.ByQuery)
+	if profile.WaitSelector != "" {
+		wait = chromedp.WaitVisible(profile.WaitSelector, chromedp.ByQuery)
+	}
 
-	var imageURLs []string
+	var extracted []string
+	var finalURL string
 
-	err := chromedp.Run(timeoutCtx,
+	err = chromedp.Run(timeoutCtx,
 		// Navigate to the page
 		chromedp.Navigate(url),
-		// Wait for the product images container to be visible
-		chromedp.WaitVisible("#js-product-images-container", chromedp.ByID),
-		// Give a little more time for everything to load - use exact same delay as working script
-		chromedp.Sleep(2*time.Second),
+		// Record where redirects ended up
+		chromedp.Location(&finalURL),
+		wait,
+		// Give a little more time for everything to load
+		chromedp.Sleep(s.config.PageLoadDelay),
 		// Extract all image URLs
-		chromedp.Evaluate(`
-            Array.from(document.querySelectorAll('[data-slide-id="zoom"]'))
-                .map(el => el.getAttribute('href'))
-                .filter(href => href && href.startsWith('https://'))
-        `, &imageURLs),
+		chromedp.Evaluate(profile.extractScript(), &extracted),
 	)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to scrape URL %s: %v", url, err)
+		return nil, finalURL, fmt.Errorf("failed to scrape URL %s with profile %s: %v", url, profile.Name, err)
 	}
-	return imageURLs, nil
+
+	imageURLs := make([]string, 0, len(extracted))
+	for _, imageURL := range extracted {
+		if profile.keep(imageURL) {
+			imageURLs = append(imageURLs, imageURL)
+		}
+	}
+	return imageURLs, finalURL, nil
+}
+
+// Pool returns the browser pool used by the scraper
+func (s *Scraper) Pool() *Pool {
+	return s.pool
 }
 
-// Cleanup releases resources - now simplified since we don't maintain browser pool
+// Cleanup shuts down the pooled browsers
 func (s *Scraper) Cleanup() {
-	log.Println("Cleanup completed - using fresh contexts per request")
+	s.pool.Close()
+	log.Println("Cleanup completed - browser pool closed")
 }
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/product-scraper/internal/config"
+	"github.com/product-scraper/internal/models"
+)
+
+// HTTPSink POSTs results in batches to an HTTP endpoint. Each request body is
+// a JSON object with a "results" array; failures are included with success
+// set to false.
+type HTTPSink struct {
+	config *config.Config
+	client *http.Client
+	mutex  sync.Mutex
+	batch  []models.ProductResult
+}
+
+// httpBatch is the body of a request sent by HTTPSink
+type httpBatch struct {
+	Results []models.ProductResult `json:"results"`
+}
+
+// NewHTTPSink creates an HTTP sink posting to HTTP_SINK_URL
+func NewHTTPSink(cfg *config.Config) (*HTTPSink, error) {
+	if cfg.HTTPSinkURL == "" {
+		return nil, fmt.Errorf("HTTP_SINK_URL is required for the http output sink")
+	}
+	return &HTTPSink{
+		config: cfg,
+		client: &http.Client{Timeout: cfg.RequestTimeout},
+		batch:  make([]models.ProductResult, 0, cfg.HTTPSinkBatchSize),
+	}, nil
+}
+
+func (s *HTTPSink) WriteResult(result models.ProductResult) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.batch = append(s.batch, result)
+	if len(s.batch) < s.config.HTTPSinkBatchSize {
+		return nil
+	}
+	return s.flush()
+}
+
+func (s *HTTPSink) WriteFailure(result models.ProductResult) error {
+	return s.WriteResult(result)
+}
+
+// Close sends any results still waiting in the batch
+func (s *HTTPSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.flush()
+}
+
+// flush sends the current batch, retrying up to MaxRetries times. The caller
+// must hold the mutex.
+func (s *HTTPSink) flush() error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(httpBatch{Results: s.batch})
+	if err != nil {
+		return fmt.Errorf("http sink: %v", err)
+	}
+
+	attempts := s.config.MaxRetries
+	if attempts < 1 {
+		attempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		err = s.post(body)
+		if err == nil || attempt >= attempts {
+			break
+		}
+		log.Printf("HTTP sink: Failed to send %d results (attempt %d/%d): %v", len(s.batch), attempt, attempts, err)
+		time.Sleep(s.config.RetryDelay)
+	}
+
+	// The batch is dropped either way so one bad batch does not block the rest
+	count := len(s.batch)
+	s.batch = s.batch[:0]
+	if err != nil {
+		return fmt.Errorf("http sink: failed to send %d results: %v", count, err)
+	}
+	return nil
+}
+
+func (s *HTTPSink) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.config.HTTPSinkURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.config.HTTPSinkToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.config.HTTPSinkToken)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
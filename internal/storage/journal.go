@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/product-scraper/internal/models"
+)
+
+// RecoverJournal replays the journal left by a crashed or interrupted run
+// into the manager and returns the number of products recovered. A missing
+// journal is not an error.
+func (m *Manager) RecoverJournal() (int, error) {
+	if m.config.JournalFile == "" {
+		return 0, nil
+	}
+
+	results, failedURLs, err := replayJournal(m.config.JournalFile)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to recover journal: %v", err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.allResults, m.failedURLs = results, failedURLs
+	for _, result := range results {
+		m.completed[result.ID] = true
+	}
+	for _, failed := range failedURLs {
+		m.completed[failed.ID] = true
+	}
+
+	recovered := len(results) + len(failedURLs)
+	if recovered > 0 {
+		log.Printf("Recovered %d results and %d failed URLs from journal %s",
+			len(results), len(failedURLs), m.config.JournalFile)
+	}
+	return recovered, nil
+}
+
+// OpenJournal starts the journal for this run. The journal is rewritten with
+// the results and failures already held by the manager, for instance those
+// recovered or resumed, and every later result is appended to it.
+func (m *Manager) OpenJournal() error {
+	if m.config.JournalFile == "" {
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Write the compacted journal next to the old one and swap it in, so a
+	// crash here never loses the recovered entries
+	tmpFile := m.config.JournalFile + ".tmp"
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return fmt.Errorf("failed to create journal: %v", err)
+	}
+	m.journal = file
+	m.journalEncoder = json.NewEncoder(file)
+	for i := range m.allResults {
+		m.writeJournal(models.JournalEntry{Result: &m.allResults[i]})
+	}
+	for i := range m.failedURLs {
+		m.writeJournal(models.JournalEntry{Failure: &m.failedURLs[i]})
+	}
+	if err := m.closeJournal(); err != nil {
+		return fmt.Errorf("failed to write journal: %v", err)
+	}
+	if err := os.Rename(tmpFile, m.config.JournalFile); err != nil {
+		return fmt.Errorf("failed to write journal: %v", err)
+	}
+
+	file, err = os.OpenFile(m.config.JournalFile, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %v", err)
+	}
+	m.journal = file
+	m.journalEncoder = json.NewEncoder(file)
+	return nil
+}
+
+// ClearJournal removes the journal once a run has completed
+func (m *Manager) ClearJournal() error {
+	if m.config.JournalFile == "" {
+		return nil
+	}
+	if err := os.Remove(m.config.JournalFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove journal: %v", err)
+	}
+	return nil
+}
+
+// writeJournal appends an entry to the journal, syncing it to disk every
+// JournalSyncInterval entries. The caller must hold the mutex.
+func (m *Manager) writeJournal(entry models.JournalEntry) {
+	if m.journal == nil {
+		return
+	}
+
+	// Each entry is a single write, so a crash can at worst truncate the last line
+	if err := m.journalEncoder.Encode(entry); err != nil {
+		log.Printf("Failed to write journal entry: %v", err)
+		return
+	}
+
+	m.sinceJournalSync++
+	if m.sinceJournalSync >= m.config.JournalSyncInterval {
+		if err := m.journal.Sync(); err != nil {
+			log.Printf("Failed to sync journal: %v", err)
+		}
+		m.sinceJournalSync = 0
+	}
+}
+
+// CloseJournal syncs and closes the journal without writing any output, for
+// runs that stop on an error and are to be resumed later
+func (m *Manager) CloseJournal() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.closeJournal(); err != nil {
+		return fmt.Errorf("failed to close journal: %v", err)
+	}
+	return nil
+}
+
+// closeJournal syncs and closes the journal. The caller must hold the mutex.
+func (m *Manager) closeJournal() error {
+	if m.journal == nil {
+		return nil
+	}
+
+	file := m.journal
+	m.journal, m.journalEncoder, m.sinceJournalSync = nil, nil, 0
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// replayJournal reads a journal and returns the latest result or failure of
+// every product, in the order the products first appeared. A truncated last
+// line, left by a crash mid-write, is ignored.
+func replayJournal(filename string) ([]models.ProductResult, []models.FailedURL, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	results := make([]models.ProductResult, 0)
+	failedURLs := make([]models.FailedURL, 0)
+	resultIndex := make(map[string]int)
+	failedIndex := make(map[string]int)
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for line := 1; ; line++ {
+		var entry models.JournalEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Journal %s: ignoring unreadable entry %d and anything after it: %v", filename, line, err)
+			break
+		}
+
+		switch {
+		case entry.Result != nil:
+			result := *entry.Result
+			if i, ok := resultIndex[result.ID]; ok {
+				results[i] = result
+			} else {
+				resultIndex[result.ID] = len(results)
+				results = append(results, result)
+			}
+			// A product that succeeded is no longer failed
+			if i, ok := failedIndex[result.ID]; ok {
+				failedURLs = append(failedURLs[:i], failedURLs[i+1:]...)
+				delete(failedIndex, result.ID)
+				for id, j := range failedIndex {
+					if j > i {
+						failedIndex[id] = j - 1
+					}
+				}
+			}
+		case entry.Failure != nil:
+			failed := *entry.Failure
+			if i, ok := failedIndex[failed.ID]; ok {
+				failedURLs[i] = failed
+			} else {
+				failedIndex[failed.ID] = len(failedURLs)
+				failedURLs = append(failedURLs, failed)
+			}
+		}
+	}
+
+	return results, failedURLs, nil
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/product-scraper/internal/models"
+)
+
+// LoadResumeData restores the results, failures and completed product IDs of
+// an interrupted run. It returns the number of completed products, or 0 if
+// there is nothing to resume.
+func (m *Manager) LoadResumeData() (int, error) {
+	var resume models.ResumeData
+	err := loadJSON(m.config.ResumeDataFile, &resume)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load resume data: %v", err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.allResults = append(m.allResults, resume.Results...)
+	m.failedURLs = append(m.failedURLs, resume.FailedURLs...)
+	for _, id := range resume.CompletedIDs {
+		m.completed[id] = true
+	}
+
+	log.Printf("Resume data from %s: %d completed products (%d results, %d failures)",
+		resume.Timestamp.Format(time.RFC3339), len(m.completed), len(resume.Results), len(resume.FailedURLs))
+	return len(m.completed), nil
+}
+
+// IsCompleted reports whether a product was already scraped, successfully
+// or not, in this or a resumed run
+func (m *Manager) IsCompleted(id string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.completed[id]
+}
+
+// Checkpoint persists the resume state
+func (m *Manager) Checkpoint() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.checkpoint()
+}
+
+// ClearResumeData removes the resume state once a run has completed
+func (m *Manager) ClearResumeData() error {
+	if m.config.ResumeDataFile == "" {
+		return nil
+	}
+	if err := os.Remove(m.config.ResumeDataFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove resume data: %v", err)
+	}
+	return nil
+}
+
+// markCompleted records a finished product and checkpoints every
+// CheckpointInterval products. With a journal, which already holds every
+// result, there are no periodic checkpoints; each one rewrites all results
+// so far. The caller must hold the mutex.
+func (m *Manager) markCompleted(id string) {
+	m.completed[id] = true
+	if m.config.JournalFile != "" {
+		return
+	}
+	m.sinceCheckpoint++
+
+	if m.config.CheckpointInterval > 0 && m.sinceCheckpoint >= m.config.CheckpointInterval {
+		if err := m.checkpoint(); err != nil {
+			log.Printf("Failed to save checkpoint: %v", err)
+		}
+	}
+}
+
+// checkpoint writes the resume state. The caller must hold the mutex.
+func (m *Manager) checkpoint() error {
+	if m.config.ResumeDataFile == "" {
+		return nil
+	}
+
+	completed := make([]string, 0, len(m.completed))
+	for id := range m.completed {
+		completed = append(completed, id)
+	}
+	sort.Strings(completed)
+
+	resume := models.ResumeData{
+		LastProcessedIndex: len(completed),
+		CompletedIDs:       completed,
+		Results:            m.allResults,
+		FailedURLs:         m.failedURLs,
+		Timestamp:          time.Now(),
+	}
+	if err := saveToJSON(m.config.ResumeDataFile, resume); err != nil {
+		return fmt.Errorf("failed to save resume data: %v", err)
+	}
+
+	m.sinceCheckpoint = 0
+	return nil
+}
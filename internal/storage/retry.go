@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/product-scraper/internal/models"
+)
+
+// LoadPreviousRun restores the final output and failed URLs of a previous run
+// so that a retry run can merge into them. It returns the failed URLs to retry.
+func (m *Manager) LoadPreviousRun() ([]models.FailedURL, error) {
+	var failedURLs []models.FailedURL
+	if err := loadJSON(m.config.FailedURLsFile, &failedURLs); err != nil {
+		return nil, fmt.Errorf("failed to load failed URLs: %v", err)
+	}
+
+	var results []models.ProductResult
+	if err := loadJSON(m.config.FinalOutputFile, &results); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to load final output: %v", err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.allResults = append(m.allResults, results...)
+	m.failedURLs = append(m.failedURLs, failedURLs...)
+
+	log.Printf("Loaded previous run: %d results, %d failed URLs", len(results), len(failedURLs))
+	return failedURLs, nil
+}
+
+// removeFailed drops the failed URL entry of a product that has now been
+// scraped successfully. The caller must hold the mutex.
+func (m *Manager) removeFailed(id string) {
+	for i, failed := range m.failedURLs {
+		if failed.ID == id {
+			m.failedURLs = append(m.failedURLs[:i], m.failedURLs[i+1:]...)
+			return
+		}
+	}
+}
+
+// loadJSON reads a JSON file into v
+func loadJSON(filename string, v interface{}) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", filename, err)
+	}
+	return nil
+}
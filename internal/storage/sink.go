@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/product-scraper/internal/config"
+	"github.com/product-scraper/internal/models"
+	"github.com/product-scraper/internal/utils"
+)
+
+// Sink receives results as they are produced. Failures are passed as the
+// failed ProductResult so that sinks see its URL and attempt history.
+type Sink interface {
+	WriteResult(result models.ProductResult) error
+	WriteFailure(result models.ProductResult) error
+	Close() error
+}
+
+// NewSinks creates the sinks listed in OUTPUT_SINKS. The manager is always
+// the first sink, as resume, retry and recovery rely on its JSON files. File
+// sinks append to their previous contents when appendMode is set, for
+// instance when resuming.
+func NewSinks(cfg *config.Config, manager *Manager, appendMode bool) ([]Sink, error) {
+	sinks := []Sink{manager}
+	for _, name := range cfg.OutputSinks {
+		var sink Sink
+		var err error
+		switch strings.ToLower(name) {
+		case "json":
+			continue
+		case "jsonl":
+			sink, err = NewJSONLSink(cfg.JSONLOutputFile, appendMode)
+		case "csv":
+			sink, err = NewCSVSink(cfg.CSVOutputFile, appendMode)
+		case "http":
+			sink, err = NewHTTPSink(cfg)
+		default:
+			err = fmt.Errorf("unknown output sink %q (available: json, jsonl, csv, http)", name)
+		}
+		if err != nil {
+			for _, opened := range sinks[1:] {
+				opened.Close()
+			}
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// ProcessResults writes the results read from resultChan to every sink until
+// the channel is closed or the context is done. Products collapsed into a
+// finished product under the alias policy get a copy of its result.
+func ProcessResults(ctx context.Context, resultChan <-chan models.ProductResult, sinks []Sink, dedup *utils.Deduplicator) {
+	log.Println("ProcessResults: Started.")
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("ProcessResults: Context done, stopping.")
+			return
+		case result, ok := <-resultChan:
+			if !ok {
+				log.Println("ProcessResults: Result channel closed, stopping.")
+				return
+			}
+
+			// A product cut short by shutdown is not done; leave it for the resumed run
+			if !result.Success && ctx.Err() != nil {
+				continue
+			}
+
+			if !result.Success {
+				log.Printf("ProcessResults: Received failed result for ID %s after %d attempts: %s",
+					result.ID, len(result.Attempts), result.Error)
+			}
+			WriteResult(sinks, result)
+
+			dedup.Finish(result)
+			WriteAliasResults(sinks, dedup)
+		}
+	}
+}
+
+// SeedAliasResults passes the results and failures held by the manager,
+// those of products completed in an earlier run, to the deduplicator, so that
+// their duplicates read in this run get a copy under the alias policy
+func SeedAliasResults(manager *Manager, dedup *utils.Deduplicator) {
+	for _, result := range manager.Results() {
+		dedup.Finish(result)
+	}
+	for _, failed := range manager.FailedURLs() {
+		dedup.Finish(models.ProductResult{
+			ID:       failed.ID,
+			URL:      failed.URL,
+			Error:    failed.Error,
+			Attempts: failed.History,
+		})
+	}
+}
+
+// WriteAliasResults writes the alias results that are ready. It is called
+// once more after the input is exhausted, for duplicates read after their
+// product finished.
+func WriteAliasResults(sinks []Sink, dedup *utils.Deduplicator) {
+	for _, result := range dedup.AliasResults() {
+		WriteResult(sinks, result)
+	}
+}
+
+// WriteResult passes a result to every sink. A failing sink is logged and
+// does not stop the others.
+func WriteResult(sinks []Sink, result models.ProductResult) {
+	for _, sink := range sinks {
+		var err error
+		if result.Success {
+			err = sink.WriteResult(result)
+		} else {
+			err = sink.WriteFailure(result)
+		}
+		if err != nil {
+			log.Printf("ProcessResults: Failed to write result for ID %s: %v", result.ID, err)
+		}
+	}
+}
+
+// openSinkFile opens an output file for a sink, truncating it unless
+// appendMode is set, and reports whether it already has content
+func openSinkFile(filename string, appendMode bool) (*os.File, bool, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appendMode {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(filename, flags, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, false, err
+	}
+	return file, info.Size() > 0, nil
+}
+
+// JSONLSink writes every result, successful or not, as one JSON line
+type JSONLSink struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// NewJSONLSink creates a JSONL sink writing to filename
+func NewJSONLSink(filename string, appendMode bool) (*JSONLSink, error) {
+	file, _, err := openSinkFile(filename, appendMode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open JSONL output: %v", err)
+	}
+	return &JSONLSink{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (s *JSONLSink) WriteResult(result models.ProductResult) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.encoder.Encode(result); err != nil {
+		return fmt.Errorf("jsonl sink: %v", err)
+	}
+	return nil
+}
+
+func (s *JSONLSink) WriteFailure(result models.ProductResult) error {
+	return s.WriteResult(result)
+}
+
+func (s *JSONLSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
+
+// csvHeader lists the columns written by CSVSink
+var csvHeader = []string{"id", "url", "final_url", "success", "error", "attempts", "image_count", "images", "metadata"}
+
+// CSVSink writes one row per result. Image URLs are space separated and the
+// metadata is written as a JSON object.
+type CSVSink struct {
+	mutex  sync.Mutex
+	file   *os.File
+	writer *csv.Writer
+}
+
+// NewCSVSink creates a CSV sink writing to filename
+func NewCSVSink(filename string, appendMode bool) (*CSVSink, error) {
+	file, hasContent, err := openSinkFile(filename, appendMode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV output: %v", err)
+	}
+
+	s := &CSVSink{file: file, writer: csv.NewWriter(file)}
+	if !hasContent {
+		if err := s.writer.Write(csvHeader); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to write CSV header: %v", err)
+		}
+	}
+	return s, nil
+}
+
+func (s *CSVSink) WriteResult(result models.ProductResult) error {
+	var metadata string
+	if len(result.Metadata) > 0 {
+		data, err := json.Marshal(result.Metadata)
+		if err != nil {
+			return fmt.Errorf("csv sink: %v", err)
+		}
+		metadata = string(data)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.writer.Write([]string{
+		result.ID,
+		result.URL,
+		result.FinalURL,
+		strconv.FormatBool(result.Success),
+		result.Error,
+		strconv.Itoa(len(result.Attempts)),
+		strconv.Itoa(len(result.Images)),
+		strings.Join(result.Images, " "),
+		metadata,
+	})
+	// Flush per row so the file is usable while the run is in progress
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		return fmt.Errorf("csv sink: %v", err)
+	}
+	return nil
+}
+
+func (s *CSVSink) WriteFailure(result models.ProductResult) error {
+	return s.WriteResult(result)
+}
+
+func (s *CSVSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -17,6 +17,15 @@ type Manager struct {
 	mutex      sync.RWMutex
 	failedURLs []models.FailedURL
 	allResults []models.ProductResult
+
+	// Resume state; see resume.go
+	completed       map[string]bool
+	sinceCheckpoint int
+
+	// Results journal; see journal.go
+	journal          *os.File
+	journalEncoder   *json.Encoder
+	sinceJournalSync int
 }
 
 func NewManager(cfg *config.Config) *Manager {
@@ -24,6 +33,7 @@ func NewManager(cfg *config.Config) *Manager {
 		config:     cfg,
 		failedURLs: make([]models.FailedURL, 0),
 		allResults: make([]models.ProductResult, 0),
+		completed:  make(map[string]bool),
 	}
 }
 
@@ -33,36 +43,65 @@ func (m *Manager) SaveResult(result models.ProductResult) {
 	defer m.mutex.Unlock()
 	m.allResults = append(m.allResults, result)
 	log.Printf("Stored result for product ID: %s. Total results: %d", result.ID, len(m.allResults))
+	m.removeFailed(result.ID)
+	m.writeJournal(models.JournalEntry{Result: &result})
+	m.markCompleted(result.ID)
 }
 
 // SaveFailedURL saves a failed URL
 func (m *Manager) SaveFailedURL(id string, url string, errMsg string) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
+	m.saveFailure(id, url, errMsg, nil)
+}
+
+// SaveFailedResult saves a failed result with the URL and the history of
+// every attempt made at it
+func (m *Manager) SaveFailedResult(result models.ProductResult) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.saveFailure(result.ID, result.URL, result.Error, result.Attempts)
+}
 
-	// Look for existing entry
-	var found bool
+// saveFailure records a failure, adding its attempts to any earlier entry for
+// the product. The caller must hold the mutex.
+func (m *Manager) saveFailure(id, url, errMsg string, attempts []models.Attempt) {
+	tries := len(attempts)
+	if tries == 0 {
+		tries = 1
+	}
+
+	// Look for an existing entry, possibly loaded from a previous run
+	index := -1
 	for i, failed := range m.failedURLs {
-		if failed.ID == id && failed.URL == url {
+		if failed.ID == id {
 			// Update existing entry
+			m.failedURLs[i].URL = url
 			m.failedURLs[i].Error = errMsg
 			m.failedURLs[i].Timestamp = time.Now()
-			m.failedURLs[i].Attempts++
-			found = true
+			m.failedURLs[i].Attempts += tries
+			m.failedURLs[i].History = append(m.failedURLs[i].History, attempts...)
+			index = i
 			break
 		}
 	}
 
 	// Add new entry if not found
-	if !found {
+	if index < 0 {
 		m.failedURLs = append(m.failedURLs, models.FailedURL{
 			ID:        id,
 			URL:       url,
 			Error:     errMsg,
 			Timestamp: time.Now(),
-			Attempts:  1,
+			Attempts:  tries,
+			History:   append([]models.Attempt(nil), attempts...),
 		})
+		index = len(m.failedURLs) - 1
 	}
+
+	failed := m.failedURLs[index]
+	m.writeJournal(models.JournalEntry{Failure: &failed})
+	m.markCompleted(id)
 }
 
 // GenerateFinalOutput combines all snapshots into a single output file
@@ -70,6 +109,21 @@ func (m *Manager) GenerateFinalOutput() error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	// The journal is the durable record of the run; rebuild from it so the
+	// output matches what a recovery would produce
+	if err := m.closeJournal(); err != nil {
+		return fmt.Errorf("failed to close journal: %v", err)
+	}
+	if m.config.JournalFile != "" {
+		results, failedURLs, err := replayJournal(m.config.JournalFile)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read journal: %v", err)
+		}
+		if err == nil {
+			m.allResults, m.failedURLs = results, failedURLs
+		}
+	}
+
 	// Save any remaining failed URLs
 	m.saveFailedURLs()
 
@@ -82,6 +136,53 @@ func (m *Manager) GenerateFinalOutput() error {
 	return nil
 }
 
+// Results returns a snapshot of the successful results
+func (m *Manager) Results() []models.ProductResult {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return append([]models.ProductResult(nil), m.allResults...)
+}
+
+// FailedURLs returns a snapshot of the failed URLs
+func (m *Manager) FailedURLs() []models.FailedURL {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return append([]models.FailedURL(nil), m.failedURLs...)
+}
+
+// WriteResult implements Sink
+func (m *Manager) WriteResult(result models.ProductResult) error {
+	m.SaveResult(result)
+	return nil
+}
+
+// WriteFailure implements Sink
+func (m *Manager) WriteFailure(result models.ProductResult) error {
+	m.SaveFailedResult(result)
+	return nil
+}
+
+// Close implements Sink by writing the final output
+func (m *Manager) Close() error {
+	return m.GenerateFinalOutput()
+}
+
+// SaveValidationReport writes the input validation report
+func (m *Manager) SaveValidationReport(report models.ValidationReport) error {
+	if err := saveToJSON(m.config.ValidationReportFile, report); err != nil {
+		return fmt.Errorf("failed to save validation report: %v", err)
+	}
+	return nil
+}
+
+// SaveDedupReport writes the list of products collapsed by deduplication
+func (m *Manager) SaveDedupReport(records []models.DedupRecord) error {
+	if err := saveToJSON(m.config.DedupReportFile, records); err != nil {
+		return fmt.Errorf("failed to save dedup report: %v", err)
+	}
+	return nil
+}
+
 // saveFailedURLs saves the failed URLs to a file
 func (m *Manager) saveFailedURLs() {
 	if err := saveToJSON(m.config.FailedURLsFile, m.failedURLs); err != nil {
@@ -89,14 +190,19 @@ func (m *Manager) saveFailedURLs() {
 	}
 }
 
-// saveToJSON saves data to a JSON file
+// saveToJSON saves data to a JSON file. The data is written to a temporary
+// file first so that an interrupted write never leaves a truncated file.
 func saveToJSON(filename string, data interface{}) error {
 	jsonData, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal data: %v", err)
 	}
 
-	if err := os.WriteFile(filename, jsonData, 0644); err != nil {
+	tmpFile := filename + ".tmp"
+	if err := os.WriteFile(tmpFile, jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := os.Rename(tmpFile, filename); err != nil {
 		return fmt.Errorf("failed to write file: %v", err)
 	}
 
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/product-scraper/internal/models"
+	"github.com/product-scraper/internal/utils"
+	"github.com/xuri/excelize/v2"
+)
+
+// Status values written to the workbook
+const (
+	workbookSuccess    = "success"
+	workbookFailed     = "failed"
+	workbookNotScraped = "not scraped"
+)
+
+// workbookRow is the outcome written to one row of the workbook
+type workbookRow struct {
+	row    int
+	images []string
+	status string
+	err    string
+}
+
+// WriteWorkbook copies the input workbook to WorkbookOutputFile and appends
+// the image count, image_1..image_N, status and error columns to each
+// product's original row. Formatting and other sheets are left as they are.
+func (m *Manager) WriteWorkbook(opts utils.InputOptions) error {
+	if source := m.config.InputSource; source != "" && source != "file" {
+		return fmt.Errorf("writing results back requires INPUT_SOURCE=file, got %s", source)
+	}
+	if !utils.IsExcelFile(m.config.InputFile) {
+		return fmt.Errorf("writing results back requires an Excel input file, got %s", m.config.InputFile)
+	}
+
+	m.mutex.RLock()
+	byID := make(map[string]workbookRow)
+	byURL := make(map[string]workbookRow)
+	for _, result := range m.allResults {
+		outcome := workbookRow{images: result.Images, status: workbookSuccess}
+		byID[result.ID] = outcome
+		addURL(byURL, result.URL, outcome)
+	}
+	for _, failed := range m.failedURLs {
+		outcome := workbookRow{status: workbookFailed, err: failed.Error}
+		byID[failed.ID] = outcome
+		addURL(byURL, failed.URL, outcome)
+	}
+	m.mutex.RUnlock()
+
+	// Locate every product row; rows collapsed by deduplication share the
+	// outcome of the product with the same URL
+	sheets := make(map[string][]workbookRow)
+	var order []string
+	maxImages := 0
+	err := utils.StreamProductsFromExcel(m.config.InputFile, opts, func(product models.Product) error {
+		if product.ID == "" {
+			return nil
+		}
+		outcome, ok := byID[product.ID]
+		if !ok {
+			if key, err := utils.CanonicalURL(product.Link); err == nil {
+				outcome, ok = byURL[key]
+			}
+		}
+		if !ok {
+			outcome = workbookRow{status: workbookNotScraped}
+		}
+		outcome.row = product.Row
+
+		if _, seen := sheets[product.Sheet]; !seen {
+			order = append(order, product.Sheet)
+		}
+		sheets[product.Sheet] = append(sheets[product.Sheet], outcome)
+		if len(outcome.images) > maxImages {
+			maxImages = len(outcome.images)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read input workbook: %v", err)
+	}
+
+	f, err := excelize.OpenFile(m.config.InputFile)
+	if err != nil {
+		return fmt.Errorf("failed to open input workbook: %v", err)
+	}
+	defer f.Close()
+
+	headerRow := opts.HeaderRow
+	if headerRow < 1 {
+		headerRow = 1
+	}
+
+	for _, sheet := range order {
+		if err := writeWorkbookSheet(f, sheet, headerRow, maxImages, sheets[sheet]); err != nil {
+			return fmt.Errorf("failed to write sheet %s: %v", sheet, err)
+		}
+	}
+
+	if err := f.SaveAs(m.config.WorkbookOutputFile); err != nil {
+		return fmt.Errorf("failed to save workbook: %v", err)
+	}
+
+	log.Printf("Results written back to %s", m.config.WorkbookOutputFile)
+	return nil
+}
+
+// writeWorkbookSheet appends the result columns to one sheet, after its last
+// used column
+func writeWorkbookSheet(f *excelize.File, sheet string, headerRow, maxImages int, rows []workbookRow) error {
+	lastCol, err := lastColumn(f, sheet)
+	if err != nil {
+		return err
+	}
+
+	headers := []string{"image_count"}
+	for i := 1; i <= maxImages; i++ {
+		headers = append(headers, "image_"+strconv.Itoa(i))
+	}
+	headers = append(headers, "status", "error")
+
+	// New headers take the style of the last existing header
+	headerStyle := 0
+	if lastCol > 0 {
+		cell, _ := excelize.CoordinatesToCellName(lastCol, headerRow)
+		headerStyle, _ = f.GetCellStyle(sheet, cell)
+	}
+	for i, header := range headers {
+		cell, _ := excelize.CoordinatesToCellName(lastCol+1+i, headerRow)
+		if err := f.SetCellValue(sheet, cell, header); err != nil {
+			return err
+		}
+		if headerStyle != 0 {
+			f.SetCellStyle(sheet, cell, cell, headerStyle)
+		}
+	}
+
+	for _, row := range rows {
+		values := []interface{}{len(row.images)}
+		for i := 0; i < maxImages; i++ {
+			if i < len(row.images) {
+				values = append(values, row.images[i])
+			} else {
+				values = append(values, nil)
+			}
+		}
+		values = append(values, row.status, row.err)
+
+		for i, value := range values {
+			if value == nil {
+				continue
+			}
+			cell, _ := excelize.CoordinatesToCellName(lastCol+1+i, row.row)
+			if err := f.SetCellValue(sheet, cell, value); err != nil {
+				return err
+			}
+			if i > 0 && i <= len(row.images) {
+				if err := f.SetCellHyperLink(sheet, cell, row.images[i-1], "External"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// lastColumn returns the 1-based index of the last used column of a sheet
+func lastColumn(f *excelize.File, sheet string) (int, error) {
+	rows, err := f.Rows(sheet)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	last := 0
+	for rows.Next() {
+		cols, err := rows.Columns()
+		if err != nil {
+			return 0, err
+		}
+		if len(cols) > last {
+			last = len(cols)
+		}
+	}
+	return last, rows.Error()
+}
+
+// addURL indexes an outcome by canonical URL, keeping the first one seen
+func addURL(byURL map[string]workbookRow, link string, outcome workbookRow) {
+	key, err := utils.CanonicalURL(link)
+	if err != nil || link == "" {
+		return
+	}
+	if _, exists := byURL[key]; !exists {
+		byURL[key] = outcome
+	}
+}
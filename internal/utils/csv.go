@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/product-scraper/internal/models"
+)
+
+// Candidate delimiters, in order of preference when counts are tied
+var csvDelimiters = []rune{',', '\t', ';', '|'}
+
+// LoadProductsFromCSV loads products from a CSV or TSV file. The delimiter is
+// detected from the header line and the file may be UTF-8 (with or without a
+// BOM) or UTF-16, as written by Excel's "Unicode Text" export.
+func LoadProductsFromCSV(filename string, opts InputOptions) ([]models.Product, error) {
+	products, err := collectProducts(func(fn ProductHandler) error {
+		return StreamProductsFromCSV(filename, opts, fn)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(products) == 0 {
+		return nil, fmt.Errorf("no valid products found in CSV file")
+	}
+
+	return products, nil
+}
+
+// StreamProductsFromCSV reads products from a CSV or TSV file one record at a time
+func StreamProductsFromCSV(filename string, opts InputOptions, fn ProductHandler) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV file: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := newDecodedReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to read CSV file: %v", err)
+	}
+
+	buffered := bufio.NewReader(reader)
+	delimiter := detectDelimiter(buffered, filepath.Ext(filename))
+
+	r := csv.NewReader(buffered)
+	r.Comma = delimiter
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	// Skip anything above the header row
+	var headers []string
+	for i := 0; i <= opts.headerIndex(); i++ {
+		headers, err = r.Read()
+		if err == io.EOF {
+			return fmt.Errorf("CSV file has no data rows")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read CSV header: %v", err)
+		}
+	}
+
+	idCol, linkCol := findColumns(headers, opts)
+	if idCol == -1 || linkCol == -1 {
+		return fmt.Errorf("required columns (ID and Link) not found in CSV file")
+	}
+
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read CSV row: %v", err)
+		}
+
+		// Skip blank lines
+		if isBlankRow(row) {
+			continue
+		}
+
+		// Rows without ID or link are passed on so that they can be reported
+		line, _ := r.FieldPos(0)
+		product := models.Product{
+			ID:       cellAt(row, idCol),
+			Link:     cellAt(row, linkCol),
+			Metadata: rowMetadata(headers, row, idCol, linkCol),
+			Row:      line,
+		}
+		if err := fn(product); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// detectDelimiter picks the delimiter that occurs most often in the first line.
+// The line is only peeked, so the reader is left untouched.
+func detectDelimiter(r *bufio.Reader, ext string) rune {
+	fallback := ','
+	if strings.EqualFold(ext, ".tsv") || strings.EqualFold(ext, ".txt") {
+		fallback = '\t'
+	}
+
+	sample, _ := r.Peek(4096)
+	if i := bytes.IndexByte(sample, '\n'); i >= 0 {
+		sample = sample[:i]
+	}
+
+	best, bestCount := fallback, 0
+	for _, delimiter := range csvDelimiters {
+		count := 0
+		inQuotes := false
+		for _, c := range string(sample) {
+			switch {
+			case c == '"':
+				inQuotes = !inQuotes
+			case c == delimiter && !inQuotes:
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = delimiter, count
+		}
+	}
+
+	return best
+}
+
+// newDecodedReader returns a reader yielding UTF-8 text with any byte order
+// mark removed. UTF-16 input is detected by its BOM or, failing that, by the
+// zero bytes an ASCII header leaves in every other position.
+func newDecodedReader(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	head, err := buffered.Peek(4)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		buffered.Discard(3)
+		return buffered, nil
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		buffered.Discard(2)
+		return &utf16Reader{r: buffered, littleEndian: true}, nil
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		buffered.Discard(2)
+		return &utf16Reader{r: buffered}, nil
+	case len(head) == 4 && head[0] != 0 && head[1] == 0 && head[2] != 0 && head[3] == 0:
+		return &utf16Reader{r: buffered, littleEndian: true}, nil
+	case len(head) == 4 && head[0] == 0 && head[1] != 0 && head[2] == 0 && head[3] != 0:
+		return &utf16Reader{r: buffered}, nil
+	}
+
+	return buffered, nil
+}
+
+// utf16Reader converts a UTF-16 byte stream into UTF-8
+type utf16Reader struct {
+	r            *bufio.Reader
+	littleEndian bool
+	pending      []byte
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	for len(u.pending) < len(p) {
+		unit, err := u.readUnit()
+		if err != nil {
+			if len(u.pending) > 0 {
+				break
+			}
+			return 0, err
+		}
+
+		r := rune(unit)
+		if utf16.IsSurrogate(r) {
+			low, err := u.readUnit()
+			if err != nil {
+				r = utf8.RuneError
+			} else {
+				r = utf16.DecodeRune(r, rune(low))
+			}
+		}
+		u.pending = utf8.AppendRune(u.pending, r)
+	}
+
+	n := copy(p, u.pending)
+	u.pending = u.pending[n:]
+	return n, nil
+}
+
+func (u *utf16Reader) readUnit() (uint16, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(u.r, b[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return 0, err
+	}
+	if u.littleEndian {
+		return uint16(b[0]) | uint16(b[1])<<8, nil
+	}
+	return uint16(b[0])<<8 | uint16(b[1]), nil
+}
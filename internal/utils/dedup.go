@@ -0,0 +1,232 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/product-scraper/internal/models"
+)
+
+// Policies for products with different IDs that share a canonical URL
+const (
+	// DedupKeepFirst scrapes the URL once under the first ID and drops the others
+	DedupKeepFirst = "first"
+	// DedupAlias scrapes the URL once and copies the result to every ID sharing it
+	DedupAlias = "alias"
+	// DedupKeepAll scrapes every ID separately; only repeated IDs are collapsed
+	DedupKeepAll = "all"
+	// DedupOff disables deduplication
+	DedupOff = "off"
+)
+
+// Reasons recorded for collapsed products
+const (
+	DedupReasonDuplicateID  = "duplicate_id"
+	DedupReasonDuplicateURL = "duplicate_url"
+)
+
+// trackingParams are query parameters stripped from canonical URLs in
+// addition to anything starting with utm_
+var trackingParams = map[string]bool{
+	"gclid":   true,
+	"dclid":   true,
+	"fbclid":  true,
+	"msclkid": true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_ga":     true,
+	"_gl":     true,
+	"igshid":  true,
+	"yclid":   true,
+}
+
+// CanonicalURL normalises a product URL for comparison: the scheme and host
+// are lower-cased, default ports, fragments and tracking parameters are
+// removed, the remaining query is sorted and trailing slashes are dropped.
+func CanonicalURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("URL %q has no host", raw)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && !(u.Scheme == "http" && port == "80") && !(u.Scheme == "https" && port == "443") {
+		host = host + ":" + port
+	}
+	u.Host = host
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	query := u.Query()
+	for key := range query {
+		if strings.HasPrefix(strings.ToLower(key), "utm_") || trackingParams[strings.ToLower(key)] {
+			query.Del(key)
+		}
+	}
+	// Encode sorts by key
+	u.RawQuery = query.Encode()
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	return u.String(), nil
+}
+
+// Deduplicator drops products that repeat an ID or a canonical URL seen
+// earlier in the input and records what was collapsed
+type Deduplicator struct {
+	mutex   sync.Mutex
+	policy  string
+	ids     map[string]models.Product
+	urls    map[string]models.Product
+	aliases map[string][]models.Product
+	records []models.DedupRecord
+
+	// Under the alias policy, the results of finished products and the alias
+	// results waiting to be written
+	finished map[string]models.ProductResult
+	ready    []models.ProductResult
+}
+
+// NewDeduplicator creates a deduplicator applying policy to conflicting IDs
+func NewDeduplicator(policy string) *Deduplicator {
+	switch policy {
+	case DedupKeepFirst, DedupAlias, DedupKeepAll, DedupOff:
+	default:
+		policy = DedupKeepFirst
+	}
+	return &Deduplicator{
+		policy:  policy,
+		ids:     make(map[string]models.Product),
+		urls:    make(map[string]models.Product),
+		aliases: make(map[string][]models.Product),
+		records: make([]models.DedupRecord, 0),
+
+		finished: make(map[string]models.ProductResult),
+	}
+}
+
+// Add reports whether a product should be scraped or was collapsed into an
+// earlier one
+func (d *Deduplicator) Add(product models.Product) bool {
+	return d.add(product, false)
+}
+
+// AddCompleted registers a product completed in an earlier run. It claims
+// its ID and URL like Add, so later duplicates are collapsed into it, but is
+// never given an alias result itself. Under the alias policy the URL is only
+// claimed if the product's result was passed to Finish; otherwise later
+// duplicates are scraped, as there is no result to copy to them.
+func (d *Deduplicator) AddCompleted(product models.Product) {
+	d.add(product, true)
+}
+
+// add implements Add and AddCompleted
+func (d *Deduplicator) add(product models.Product, completed bool) bool {
+	if d.policy == DedupOff {
+		return true
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	canonical, err := CanonicalURL(product.Link)
+	if err != nil {
+		canonical = product.Link
+	}
+
+	// The first row for an ID wins, even if that row was itself collapsed
+	if kept, seen := d.ids[product.ID]; seen {
+		d.record(product, canonical, kept, DedupReasonDuplicateID)
+		return false
+	}
+	d.ids[product.ID] = product
+
+	first, urlSeen := d.urls[canonical]
+	if urlSeen && d.policy != DedupKeepAll {
+		d.record(product, canonical, first, DedupReasonDuplicateURL)
+		if d.policy == DedupAlias && !completed {
+			// Input is streamed, so the product may already be done
+			if result, done := d.finished[first.ID]; done {
+				d.ready = append(d.ready, aliasResult(result, product))
+			} else {
+				d.aliases[first.ID] = append(d.aliases[first.ID], product)
+			}
+		}
+		return false
+	}
+
+	if !urlSeen {
+		// Without a result to copy, duplicates of a completed product are scraped
+		if _, done := d.finished[product.ID]; completed && d.policy == DedupAlias && !done {
+			return true
+		}
+		d.urls[canonical] = product
+	}
+	return true
+}
+
+// Finish records the final result of a product. Under the alias policy the
+// result is copied to the products collapsed into it, now and for those
+// found later in the input; see AliasResults.
+func (d *Deduplicator) Finish(result models.ProductResult) {
+	if d.policy != DedupAlias {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.finished[result.ID] = result
+	for _, alias := range d.aliases[result.ID] {
+		d.ready = append(d.ready, aliasResult(result, alias))
+	}
+	delete(d.aliases, result.ID)
+}
+
+// AliasResults returns the alias results that became ready since the last
+// call
+func (d *Deduplicator) AliasResults() []models.ProductResult {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	ready := d.ready
+	d.ready = nil
+	return ready
+}
+
+// aliasResult copies a result to a product collapsed into it
+func aliasResult(result models.ProductResult, alias models.Product) models.ProductResult {
+	result.ID = alias.ID
+	result.URL = alias.Link
+	result.Metadata = alias.Metadata
+	return result
+}
+
+// Records returns what was collapsed so far
+func (d *Deduplicator) Records() []models.DedupRecord {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]models.DedupRecord(nil), d.records...)
+}
+
+func (d *Deduplicator) record(product models.Product, canonical string, kept models.Product, reason string) {
+	d.records = append(d.records, models.DedupRecord{
+		ID:           product.ID,
+		Link:         product.Link,
+		Sheet:        product.Sheet,
+		Row:          product.Row,
+		CanonicalURL: canonical,
+		KeptID:       kept.ID,
+		KeptLink:     kept.Link,
+		Reason:       reason,
+	})
+}
@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// cellCoords identifies a cell by 1-based column and row
+type cellCoords struct {
+	col, row int
+}
+
+// sheetLinkTargets returns the URL each linked cell of a worksheet points to,
+// through an Excel hyperlink or a HYPERLINK() formula. The worksheet XML is
+// read from the workbook file in a single streaming pass, which keeps memory
+// flat where excelize's per-cell lookups would load the whole worksheet.
+// Hyperlinks take precedence over formulas, and links to locations inside the
+// workbook are ignored.
+func sheetLinkTargets(filename, sheetName string) (map[cellCoords]string, error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, file := range zr.File {
+		files[file.Name] = file
+	}
+
+	sheetPath, err := worksheetPath(files, sheetName)
+	if err != nil {
+		return nil, err
+	}
+	rels, err := readRelationships(files, relationshipsPath(sheetPath))
+	if err != nil {
+		return nil, err
+	}
+
+	sheet, ok := files[sheetPath]
+	if !ok {
+		return nil, fmt.Errorf("worksheet %s missing from workbook", sheetPath)
+	}
+	rc, err := sheet.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	targets := make(map[cellCoords]string)
+	hyperlinks := make(map[cellCoords]string)
+	var current string
+
+	decoder := xml.NewDecoder(rc)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "c":
+			current = xmlAttr(start, "r")
+		case "f":
+			var formula string
+			if err := decoder.DecodeElement(&formula, &start); err != nil {
+				return nil, err
+			}
+			match := hyperlinkFormula.FindStringSubmatch(formula)
+			if match == nil || !isAbsoluteURL(match[1]) {
+				continue
+			}
+			if col, row, err := excelize.CellNameToCoordinates(current); err == nil {
+				targets[cellCoords{col, row}] = strings.TrimSpace(match[1])
+			}
+		case "hyperlink":
+			target := rels[xmlAttr(start, "id")]
+			if !isAbsoluteURL(target) {
+				continue
+			}
+			for _, cell := range rangeCells(xmlAttr(start, "ref")) {
+				hyperlinks[cell] = strings.TrimSpace(target)
+			}
+		}
+	}
+
+	for cell, target := range hyperlinks {
+		targets[cell] = target
+	}
+	return targets, nil
+}
+
+// worksheetPath finds the part holding the named worksheet
+func worksheetPath(files map[string]*zip.File, sheetName string) (string, error) {
+	var workbook struct {
+		Sheets []struct {
+			Name string     `xml:"name,attr"`
+			Attr []xml.Attr `xml:",any,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := readXML(files, "xl/workbook.xml", &workbook); err != nil {
+		return "", err
+	}
+	rels, err := readRelationships(files, "xl/_rels/workbook.xml.rels")
+	if err != nil {
+		return "", err
+	}
+
+	for _, sheet := range workbook.Sheets {
+		if sheet.Name != sheetName {
+			continue
+		}
+		for _, attr := range sheet.Attr {
+			if attr.Name.Local == "id" {
+				if target, ok := rels[attr.Value]; ok {
+					return resolvePart("xl", target), nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("sheet %q not found in workbook", sheetName)
+}
+
+// readRelationships maps the relationship IDs of a part to their targets.
+// A part without relationships yields an empty map.
+func readRelationships(files map[string]*zip.File, name string) (map[string]string, error) {
+	var relationships struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	rels := make(map[string]string)
+	if _, ok := files[name]; !ok {
+		return rels, nil
+	}
+	if err := readXML(files, name, &relationships); err != nil {
+		return nil, err
+	}
+	for _, rel := range relationships.Relationships {
+		rels[rel.ID] = rel.Target
+	}
+	return rels, nil
+}
+
+// readXML decodes a small part of the workbook
+func readXML(files map[string]*zip.File, name string, v interface{}) error {
+	file, ok := files[name]
+	if !ok {
+		return fmt.Errorf("%s missing from workbook", name)
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", name, err)
+	}
+	return nil
+}
+
+// relationshipsPath returns the relationships part of a part, e.g.
+// xl/worksheets/_rels/sheet1.xml.rels for xl/worksheets/sheet1.xml
+func relationshipsPath(part string) string {
+	return path.Join(path.Dir(part), "_rels", path.Base(part)+".rels")
+}
+
+// resolvePart resolves a relationship target against the directory of the
+// part it belongs to
+func resolvePart(dir, target string) string {
+	if strings.HasPrefix(target, "/") {
+		return strings.TrimPrefix(target, "/")
+	}
+	return path.Join(dir, target)
+}
+
+// maxRangeCells bounds the cells a single hyperlink reference is expanded to
+const maxRangeCells = 100000
+
+// rangeCells lists the cells of a reference such as "B2" or "B2:B4"
+func rangeCells(ref string) []cellCoords {
+	first, last, _ := strings.Cut(ref, ":")
+	if last == "" {
+		last = first
+	}
+	col1, row1, err := excelize.CellNameToCoordinates(first)
+	if err != nil {
+		return nil
+	}
+	col2, row2, err := excelize.CellNameToCoordinates(last)
+	if err != nil {
+		return nil
+	}
+
+	// Ranges spanning whole columns are not worth expanding
+	if (row2-row1+1)*(col2-col1+1) > maxRangeCells {
+		return nil
+	}
+
+	var cells []cellCoords
+	for row := row1; row <= row2; row++ {
+		for col := col1; col <= col2; col++ {
+			cells = append(cells, cellCoords{col, row})
+		}
+	}
+	return cells
+}
+
+// xmlAttr returns the value of an attribute by local name
+func xmlAttr(start xml.StartElement, name string) string {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/product-scraper/internal/models"
+)
+
+// StdinInput is the INPUT_FILE value that makes the scraper read JSON from stdin
+const StdinInput = "-"
+
+// LoadProductsFromJSON loads products from a JSON array or newline-delimited
+// JSON objects. Objects are matched with the same aliases as spreadsheet
+// headers, so both models.Product and models.FailedURL records are accepted.
+// A filename of "-" reads from stdin.
+func LoadProductsFromJSON(filename string, opts InputOptions) ([]models.Product, error) {
+	products, err := collectProducts(func(fn ProductHandler) error {
+		return StreamProductsFromJSON(filename, opts, fn)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(products) == 0 {
+		return nil, fmt.Errorf("no valid products found in JSON input")
+	}
+
+	return products, nil
+}
+
+// StreamProductsFromJSON decodes products one record at a time
+func StreamProductsFromJSON(filename string, opts InputOptions, fn ProductHandler) error {
+	var input io.Reader = os.Stdin
+	if filename != StdinInput {
+		file, err := os.Open(filename)
+		if err != nil {
+			return fmt.Errorf("failed to open JSON file: %v", err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	reader, err := newDecodedReader(input)
+	if err != nil {
+		return fmt.Errorf("failed to read JSON input: %v", err)
+	}
+	buffered := bufio.NewReader(reader)
+
+	isArray, err := startsWithArray(buffered)
+	if err != nil {
+		return fmt.Errorf("failed to read JSON input: %v", err)
+	}
+
+	decoder := json.NewDecoder(buffered)
+	decoder.UseNumber()
+
+	if isArray {
+		// Consume the opening bracket
+		if _, err := decoder.Token(); err != nil {
+			return fmt.Errorf("failed to parse JSON array: %v", err)
+		}
+	}
+
+	for record := 1; ; record++ {
+		if isArray && !decoder.More() {
+			break
+		}
+
+		var obj map[string]interface{}
+		if err := decoder.Decode(&obj); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to parse JSON record %d: %v", record, err)
+		}
+
+		// Records without ID or link are passed on so that they can be reported
+		product := productFromJSON(obj, opts)
+		product.Row = record
+
+		if err := fn(product); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// startsWithArray reports whether the first non-whitespace byte is '['
+func startsWithArray(r *bufio.Reader) (bool, error) {
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(b)) {
+			return b == '[', r.UnreadByte()
+		}
+	}
+}
+
+// productFromJSON maps a decoded JSON object onto a product using the
+// spreadsheet header aliases. Remaining fields become metadata, with a nested
+// "metadata" object (as written for models.Product) flattened back in.
+func productFromJSON(obj map[string]interface{}, opts InputOptions) models.Product {
+	var product models.Product
+
+	if nested, ok := obj["metadata"].(map[string]interface{}); ok {
+		for key, value := range nested {
+			setMetadata(&product, key, jsonScalar(value))
+		}
+	}
+
+	// The input position of a re-read product is replaced by its record number
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		switch key {
+		case "metadata", "sheet", "row":
+		default:
+			keys = append(keys, key)
+		}
+	}
+	// Sort so that records carrying several aliases resolve deterministically
+	sort.Strings(keys)
+
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = jsonScalar(obj[key])
+	}
+
+	idCol, linkCol := findColumns(keys, opts)
+	for i, key := range keys {
+		switch i {
+		case idCol:
+			product.ID = strings.TrimSpace(values[i])
+		case linkCol:
+			product.Link = strings.TrimSpace(values[i])
+		default:
+			setMetadata(&product, key, values[i])
+		}
+	}
+
+	return product
+}
+
+// setMetadata stores a non-empty value in the product's metadata
+func setMetadata(product *models.Product, key, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	if product.Metadata == nil {
+		product.Metadata = make(map[string]string)
+	}
+	product.Metadata[key] = value
+}
+
+// jsonScalar renders a decoded JSON value as a string. Numbers keep their
+// literal form so that IDs like 00123 or 1234567890123 survive unchanged.
+func jsonScalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprint(v)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
@@ -1,18 +1,72 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"math/big"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/product-scraper/internal/models"
 	"github.com/xuri/excelize/v2"
 )
 
+// InputOptions controls how products are read from an input file
+type InputOptions struct {
+	// Sheet selects the worksheet by name or 1-based index; empty means the first sheet
+	Sheet string
+	// AllSheets reads every worksheet into one combined product list
+	AllSheets bool
+	// IDColumn and LinkColumn map a header name or column letter (e.g. "C")
+	// to the product ID and link. Empty falls back to the built-in aliases.
+	IDColumn   string
+	LinkColumn string
+	// HeaderRow is the 1-based row holding the column headers; rows above it are ignored
+	HeaderRow int
+	// IDAsText reads Excel IDs exactly as stored, ignoring number formats
+	IDAsText bool
+}
+
+// headerIndex returns the 0-based index of the header row
+func (o InputOptions) headerIndex() int {
+	if o.HeaderRow > 1 {
+		return o.HeaderRow - 1
+	}
+	return 0
+}
+
+// ProductHandler receives products as they are read from the input. Every
+// non-blank row is passed on, including rows without an ID or link, so that
+// callers can report them; see Validator. Returning an error stops the read
+// and is passed back to the caller.
+type ProductHandler func(product models.Product) error
+
 // LoadProductsFromExcel loads products from an Excel file
-func LoadProductsFromExcel(filename string) ([]models.Product, error) {
+func LoadProductsFromExcel(filename string, opts InputOptions) ([]models.Product, error) {
+	products, err := collectProducts(func(fn ProductHandler) error {
+		return StreamProductsFromExcel(filename, opts, fn)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(products) == 0 {
+		return nil, fmt.Errorf("no valid products found in Excel file")
+	}
+
+	return products, nil
+}
+
+// StreamProductsFromExcel reads products from an Excel file row by row using
+// excelize's row iterator, so memory use does not grow with the sheet size.
+func StreamProductsFromExcel(filename string, opts InputOptions, fn ProductHandler) error {
 	f, err := excelize.OpenFile(filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open Excel file: %v", err)
+		return fmt.Errorf("failed to open Excel file: %v", err)
 	}
 	defer func() {
 		if err := f.Close(); err != nil {
@@ -20,26 +74,300 @@ func LoadProductsFromExcel(filename string) ([]models.Product, error) {
 		}
 	}()
 
-	// Get the first sheet name
-	sheetName := f.GetSheetName(0)
-	if sheetName == "" {
+	sheets, err := selectSheets(f, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, sheetName := range sheets {
+		if err := streamSheet(f, sheetName, opts, fn); err != nil {
+			// When combining sheets, tolerate sheets that hold something else
+			if opts.AllSheets && errors.Is(err, errSheetLayout) {
+				log.Printf("Skipping sheet %q: %v", sheetName, err)
+				continue
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// collectProducts gathers the products a stream function produces into a
+// slice, skipping rows without ID or link
+func collectProducts(stream func(fn ProductHandler) error) ([]models.Product, error) {
+	var products []models.Product
+	err := stream(func(product models.Product) error {
+		if product.ID != "" && product.Link != "" {
+			products = append(products, product)
+		}
+		return nil
+	})
+	return products, err
+}
+
+// selectSheets returns the names of the worksheets to read
+func selectSheets(f *excelize.File, opts InputOptions) ([]string, error) {
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
 		return nil, fmt.Errorf("no sheets found in Excel file")
 	}
 
-	// Get all rows
-	rows, err := f.GetRows(sheetName)
+	if opts.AllSheets {
+		return sheets, nil
+	}
+
+	if opts.Sheet == "" {
+		return sheets[:1], nil
+	}
+
+	// A sheet name takes precedence over an index, so a sheet called "2" still works
+	for _, name := range sheets {
+		if strings.EqualFold(name, opts.Sheet) {
+			return []string{name}, nil
+		}
+	}
+
+	if index, err := strconv.Atoi(opts.Sheet); err == nil {
+		if index < 1 || index > len(sheets) {
+			return nil, fmt.Errorf("sheet index %d out of range (workbook has %d sheets)", index, len(sheets))
+		}
+		return []string{sheets[index-1]}, nil
+	}
+
+	return nil, fmt.Errorf("sheet %q not found in Excel file", opts.Sheet)
+}
+
+// errSheetLayout marks a worksheet that lacks a header row or the ID and Link columns
+var errSheetLayout = errors.New("unexpected sheet layout")
+
+// streamSheet parses the products of a single worksheet
+func streamSheet(f *excelize.File, sheetName string, opts InputOptions, fn ProductHandler) error {
+	rows, err := openSheetRows(f, sheetName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get rows: %v", err)
+		return fmt.Errorf("failed to get rows: %v", err)
 	}
+	defer rows.Close()
 
-	// Check if the sheet has any rows
-	if len(rows) < 2 {
-		return nil, fmt.Errorf("Excel file has no data rows")
+	// Skip anything above the header row. The iterator yields gaps in the
+	// sheet as empty rows, so the row number is the number of Next calls.
+	rowNum := 0
+	var headers []string
+	for rowNum <= opts.headerIndex() {
+		if !rows.Next() {
+			return fmt.Errorf("%w: Excel file has no data rows", errSheetLayout)
+		}
+		rowNum++
+		if headers, _, err = rows.Columns(); err != nil {
+			return fmt.Errorf("failed to read row %d: %v", rowNum, err)
+		}
 	}
 
 	// Find column indices for ID and Link
-	headers := rows[0]
-	idCol, linkCol := -1, -1
+	idCol, linkCol := findColumns(headers, opts)
+	if idCol == -1 || linkCol == -1 {
+		return fmt.Errorf("%w: required columns (ID and Link) not found in Excel file", errSheetLayout)
+	}
+
+	// Parse data rows
+	var links map[cellCoords]string
+	for rows.Next() {
+		rowNum++
+		row, raw, err := rows.Columns()
+		if err != nil {
+			return fmt.Errorf("failed to read row %d: %v", rowNum, err)
+		}
+
+		// Skip blank rows
+		if isBlankRow(row) {
+			continue
+		}
+
+		id := cellID(cellAt(row, idCol), cellAt(raw, idCol), opts.IDAsText)
+		link := cellAt(row, linkCol)
+
+		// Link cells often show "View" or the product name instead of the URL.
+		// The link targets of the sheet are read once, when the first such
+		// row is found.
+		if id != "" && !isAbsoluteURL(link) {
+			if links == nil {
+				if links, err = sheetLinkTargets(f.Path, sheetName); err != nil {
+					log.Printf("Warning: Could not read hyperlinks of sheet %s: %v", sheetName, err)
+					links = map[cellCoords]string{}
+				}
+			}
+			if target := links[cellCoords{linkCol + 1, rowNum}]; target != "" {
+				link = target
+			}
+		}
+
+		// Rows without ID or link are passed on so that they can be reported
+		product := models.Product{
+			ID:       id,
+			Link:     link,
+			Metadata: rowMetadata(headers, row, idCol, linkCol),
+			Sheet:    sheetName,
+			Row:      rowNum,
+		}
+		if err := fn(product); err != nil {
+			return err
+		}
+	}
+
+	return rows.Error()
+}
+
+// sheetRows walks a worksheet with two excelize row iterators in step, one
+// returning formatted values and one returning the values as stored
+type sheetRows struct {
+	formatted *excelize.Rows
+	raw       *excelize.Rows
+}
+
+func openSheetRows(f *excelize.File, sheetName string) (*sheetRows, error) {
+	formatted, err := f.Rows(sheetName)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := f.Rows(sheetName)
+	if err != nil {
+		formatted.Close()
+		return nil, err
+	}
+	return &sheetRows{formatted: formatted, raw: raw}, nil
+}
+
+func (r *sheetRows) Next() bool {
+	formatted, raw := r.formatted.Next(), r.raw.Next()
+	return formatted && raw
+}
+
+// Columns returns the formatted and the raw cells of the current row
+func (r *sheetRows) Columns() (formatted, raw []string, err error) {
+	if formatted, err = r.formatted.Columns(); err != nil {
+		return nil, nil, err
+	}
+	if raw, err = r.raw.Columns(excelize.Options{RawCellValue: true}); err != nil {
+		return nil, nil, err
+	}
+	return formatted, raw, nil
+}
+
+func (r *sheetRows) Error() error {
+	if err := r.formatted.Error(); err != nil {
+		return err
+	}
+	return r.raw.Error()
+}
+
+func (r *sheetRows) Close() error {
+	err := r.formatted.Close()
+	if rawErr := r.raw.Close(); err == nil {
+		err = rawErr
+	}
+	return err
+}
+
+// cellID picks the ID from a cell's formatted and stored values. Numeric
+// SKUs would otherwise turn into scientific notation (1.23457E+12) or lose
+// precision, so the stored number is used unless the number format pads it
+// with leading zeros (00012345). With asText the number format is ignored.
+func cellID(formatted, raw string, asText bool) string {
+	if formatted == raw {
+		return raw
+	}
+
+	number, ok := new(big.Float).SetPrec(200).SetString(raw)
+	if !ok {
+		// Text cells are used as stored
+		return raw
+	}
+	plain := number.Text('f', -1)
+
+	if !asText && isDigits(formatted) {
+		if shown, ok := new(big.Float).SetPrec(200).SetString(formatted); ok && shown.Cmp(number) == 0 {
+			return formatted
+		}
+	}
+
+	return plain
+}
+
+// isDigits reports whether s is a non-empty run of ASCII digits
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// hyperlinkFormula matches =HYPERLINK("target", ...) and captures the target
+var hyperlinkFormula = regexp.MustCompile(`(?i)^=?\s*HYPERLINK\(\s*"([^"]+)"`)
+
+// isAbsoluteURL reports whether s looks like a URL with a scheme and host
+func isAbsoluteURL(s string) bool {
+	u, err := url.Parse(strings.TrimSpace(s))
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+// LoadProducts loads products from filename, picking the loader from the file
+// extension. "-" reads JSON from stdin and anything that is not CSV/TSV or
+// JSON is treated as an Excel workbook.
+func LoadProducts(filename string, opts InputOptions) ([]models.Product, error) {
+	if filename == StdinInput {
+		return LoadProductsFromJSON(filename, opts)
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json", ".jsonl", ".ndjson":
+		return LoadProductsFromJSON(filename, opts)
+	case ".csv", ".tsv", ".txt":
+		return LoadProductsFromCSV(filename, opts)
+	default:
+		return LoadProductsFromExcel(filename, opts)
+	}
+}
+
+// IsExcelFile reports whether LoadProducts and StreamProducts read filename
+// as an Excel workbook
+func IsExcelFile(filename string) bool {
+	if filename == StdinInput {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json", ".jsonl", ".ndjson", ".csv", ".tsv", ".txt":
+		return false
+	}
+	return true
+}
+
+// StreamProducts reads products from filename like LoadProducts, but hands
+// each product to fn as soon as it is parsed instead of building a list.
+func StreamProducts(filename string, opts InputOptions, fn ProductHandler) error {
+	if filename == StdinInput {
+		return StreamProductsFromJSON(filename, opts, fn)
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json", ".jsonl", ".ndjson":
+		return StreamProductsFromJSON(filename, opts, fn)
+	case ".csv", ".tsv", ".txt":
+		return StreamProductsFromCSV(filename, opts, fn)
+	default:
+		return StreamProductsFromExcel(filename, opts, fn)
+	}
+}
+
+// findColumns returns the indices of the ID and Link columns in a header row,
+// or -1 for a column that could not be found. Columns configured in opts take
+// precedence over the built-in header aliases.
+func findColumns(headers []string, opts InputOptions) (idCol, linkCol int) {
+	idCol, linkCol = -1, -1
 
 	for i, header := range headers {
 		header = strings.ToLower(strings.TrimSpace(header))
@@ -51,54 +379,91 @@ func LoadProductsFromExcel(filename string) ([]models.Product, error) {
 		}
 	}
 
-	if idCol == -1 || linkCol == -1 {
-		return nil, fmt.Errorf("required columns (ID and Link) not found in Excel file")
+	if opts.IDColumn != "" {
+		idCol = resolveColumn(headers, opts.IDColumn)
+	}
+	if opts.LinkColumn != "" {
+		linkCol = resolveColumn(headers, opts.LinkColumn)
 	}
 
-	// Parse data rows
-	var products []models.Product
+	return idCol, linkCol
+}
 
-	for i := 1; i < len(rows); i++ {
-		row := rows[i]
+// cellAt returns the trimmed cell at index i, or "" for short rows
+func cellAt(row []string, i int) string {
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
 
-		// Skip empty rows
-		if len(row) <= idCol || len(row) <= linkCol {
-			continue
+// isBlankRow reports whether every cell of a row is empty
+func isBlankRow(row []string) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(cell) != "" {
+			return false
 		}
+	}
+	return true
+}
 
-		id := strings.TrimSpace(row[idCol])
-		link := strings.TrimSpace(row[linkCol])
+// rowMetadata collects the non-empty cells outside the ID and Link columns,
+// keyed by their header. Columns without a header are keyed by column letter.
+func rowMetadata(headers, row []string, idCol, linkCol int) map[string]string {
+	var metadata map[string]string
 
-		// Skip rows without ID or link
-		if id == "" || link == "" {
+	for i, value := range row {
+		value = strings.TrimSpace(value)
+		if i == idCol || i == linkCol || value == "" {
 			continue
 		}
 
-		products = append(products, models.Product{
-			ID:   id,
-			Link: link,
-		})
+		var key string
+		if i < len(headers) {
+			key = strings.TrimSpace(headers[i])
+		}
+		if key == "" {
+			key, _ = excelize.ColumnNumberToName(i + 1)
+		}
+
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[key] = value
 	}
 
-	if len(products) == 0 {
-		return nil, fmt.Errorf("no valid products found in Excel file")
+	return metadata
+}
+
+// resolveColumn finds a column by header name or, failing that, by column
+// letter. It returns -1 if neither matches.
+func resolveColumn(headers []string, spec string) int {
+	spec = strings.TrimSpace(spec)
+	for i, header := range headers {
+		if strings.EqualFold(strings.TrimSpace(header), spec) {
+			return i
+		}
 	}
 
-	return products, nil
+	// A column letter must fall within the header row, so that a missing
+	// header such as "EAN" is reported rather than read as column EAN
+	if len(spec) <= 3 {
+		if col, err := excelize.ColumnNameToNumber(spec); err == nil && col <= len(headers) {
+			return col - 1
+		}
+	}
+
+	return -1
 }
 
 // FilterFailedURLs filters products to only include those that previously failed
 func FilterFailedURLs(products []models.Product, failedURLs []models.FailedURL) []models.Product {
-	// Create map of failed product IDs for quick lookup
-	failedMap := make(map[string]bool)
-	for _, failed := range failedURLs {
-		failedMap[failed.ID] = true
-	}
+	failed := FailedURLFilter(failedURLs)
 
 	// Filter products
 	filtered := make([]models.Product, 0)
 	for _, product := range products {
-		if failedMap[product.ID] {
+		if failed(product) {
 			filtered = append(filtered, product)
 		}
 	}
@@ -106,6 +471,20 @@ func FilterFailedURLs(products []models.Product, failedURLs []models.FailedURL)
 	return filtered
 }
 
+// FailedURLFilter returns a predicate reporting whether a product previously
+// failed, for filtering products as they are streamed
+func FailedURLFilter(failedURLs []models.FailedURL) func(models.Product) bool {
+	// Create map of failed product IDs for quick lookup
+	failedMap := make(map[string]bool)
+	for _, failed := range failedURLs {
+		failedMap[failed.ID] = true
+	}
+
+	return func(product models.Product) bool {
+		return failedMap[product.ID]
+	}
+}
+
 // ChunkProducts splits a slice of products into chunks of the specified size
 func ChunkProducts(products []models.Product, chunkSize int) [][]models.Product {
 	if chunkSize <= 0 {
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/product-scraper/internal/models"
+)
+
+// Validation reasons recorded in the report
+const (
+	ReasonMissingID         = "missing_id"
+	ReasonMissingLink       = "missing_link"
+	ReasonMalformedURL      = "malformed_url"
+	ReasonUnsupportedScheme = "unsupported_scheme"
+	ReasonDuplicateID       = "duplicate_id"
+	ReasonDuplicateURL      = "duplicate_url"
+)
+
+// Validator checks input rows and collects a report of the rows it rejects
+// (missing fields, bad URLs) and the rows that look suspicious (duplicates).
+// Suspicious rows are still accepted.
+type Validator struct {
+	mutex    sync.Mutex
+	report   models.ValidationReport
+	seenIDs  map[string]string
+	seenURLs map[string]string
+}
+
+// NewValidator creates a validator for rows read from inputFile
+func NewValidator(inputFile string) *Validator {
+	return &Validator{
+		report: models.ValidationReport{
+			InputFile:  inputFile,
+			Skipped:    make([]models.ValidationIssue, 0),
+			Suspicious: make([]models.ValidationIssue, 0),
+		},
+		seenIDs:  make(map[string]string),
+		seenURLs: make(map[string]string),
+	}
+}
+
+// Check validates a product and reports whether it should be scraped
+func (v *Validator) Check(product models.Product) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.report.TotalRows++
+
+	skip := func(reason, detail string) bool {
+		v.report.Skipped = append(v.report.Skipped, newIssue(product, reason, detail))
+		return false
+	}
+
+	switch {
+	case product.ID == "":
+		return skip(ReasonMissingID, "")
+	case product.Link == "":
+		return skip(ReasonMissingLink, "")
+	}
+
+	u, err := url.Parse(product.Link)
+	switch {
+	case err != nil:
+		return skip(ReasonMalformedURL, err.Error())
+	case u.Scheme == "":
+		return skip(ReasonMalformedURL, "missing scheme")
+	case u.Scheme != "http" && u.Scheme != "https":
+		return skip(ReasonUnsupportedScheme, fmt.Sprintf("scheme %q", u.Scheme))
+	case u.Host == "":
+		return skip(ReasonMalformedURL, "missing host")
+	}
+
+	if first, seen := v.seenIDs[product.ID]; seen {
+		v.report.Suspicious = append(v.report.Suspicious,
+			newIssue(product, ReasonDuplicateID, "first seen in "+first))
+	} else {
+		v.seenIDs[product.ID] = rowLocation(product.Sheet, product.Row)
+	}
+
+	// URLs differing only in tracking parameters, case or fragments are duplicates
+	key, err := CanonicalURL(product.Link)
+	if err != nil {
+		key = product.Link
+	}
+	if first, seen := v.seenURLs[key]; seen {
+		v.report.Suspicious = append(v.report.Suspicious,
+			newIssue(product, ReasonDuplicateURL, "first seen in "+first))
+	} else {
+		v.seenURLs[key] = rowLocation(product.Sheet, product.Row)
+	}
+
+	v.report.ValidRows++
+	return true
+}
+
+// Report returns a snapshot of the validation report
+func (v *Validator) Report() models.ValidationReport {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	report := v.report
+	report.Timestamp = time.Now()
+	report.Skipped = append([]models.ValidationIssue(nil), v.report.Skipped...)
+	report.Suspicious = append([]models.ValidationIssue(nil), v.report.Suspicious...)
+	return report
+}
+
+// LogSummary prints a console summary of the report, listing up to maxRows
+// issues of each kind
+func (v *Validator) LogSummary(maxRows int) {
+	report := v.Report()
+
+	log.Printf("Validation: %d rows read, %d valid, %d skipped, %d suspicious",
+		report.TotalRows, report.ValidRows, len(report.Skipped), len(report.Suspicious))
+
+	logIssues("Skipped", report.Skipped, maxRows)
+	logIssues("Suspicious", report.Suspicious, maxRows)
+}
+
+func logIssues(kind string, issues []models.ValidationIssue, maxRows int) {
+	if len(issues) == 0 {
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, issue := range issues {
+		counts[issue.Reason]++
+	}
+	reasons := make([]string, 0, len(counts))
+	for reason, count := range counts {
+		reasons = append(reasons, fmt.Sprintf("%s=%d", reason, count))
+	}
+	sort.Strings(reasons)
+	log.Printf("Validation: %s rows by reason: %s", kind, strings.Join(reasons, ", "))
+
+	for i, issue := range issues {
+		if i == maxRows {
+			log.Printf("Validation:   ... and %d more (see the validation report)", len(issues)-maxRows)
+			break
+		}
+		location := rowLocation(issue.Sheet, issue.Row)
+		if issue.Detail != "" {
+			log.Printf("Validation:   %s: %s (%s) id=%q link=%q", location, issue.Reason, issue.Detail, issue.ID, issue.Link)
+		} else {
+			log.Printf("Validation:   %s: %s id=%q link=%q", location, issue.Reason, issue.ID, issue.Link)
+		}
+	}
+}
+
+// rowLocation formats a row number, prefixed with its sheet when known
+func rowLocation(sheet string, row int) string {
+	if sheet != "" {
+		return fmt.Sprintf("%s row %d", sheet, row)
+	}
+	return fmt.Sprintf("row %d", row)
+}
+
+func newIssue(product models.Product, reason, detail string) models.ValidationIssue {
+	return models.ValidationIssue{
+		Sheet:  product.Sheet,
+		Row:    product.Row,
+		ID:     product.ID,
+		Link:   product.Link,
+		Reason: reason,
+		Detail: detail,
+	}
+}
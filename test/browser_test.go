@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/product-scraper/internal/config"
+	"github.com/product-scraper/internal/models"
+	"github.com/product-scraper/internal/scraper"
+)
+
+func TestBrowserPool(t *testing.T) {
+	if !chromeAvailable() {
+		t.Skip("Chrome/Chromium not found")
+	}
+
+	// The page only lists images to visitors without a cookie, so a product
+	// that sees an earlier product's cookie fails
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		links := `<a data-slide-id="zoom" href="https://cdn.example.com/1.jpg">1</a>`
+		if _, err := r.Cookie("visited"); err == nil {
+			links = ""
+		}
+		http.SetCookie(w, &http.Cookie{Name: "visited", Value: "1", Path: "/"})
+		fmt.Fprintf(w, `<html><body><div id="js-product-images-container">%s</div></body></html>`, links)
+	}))
+	defer server.Close()
+
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.MaxRetries = 1
+	cfg.BrowserPoolSize = 1
+	cfg.BrowserRecyclePages = 2
+
+	s, err := scraper.New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create scraper: %v", err)
+	}
+	defer s.Cleanup()
+
+	for i := 1; i <= 3; i++ {
+		result := s.ScrapeProduct(context.Background(), 1, models.Product{
+			ID:   fmt.Sprintf("p%d", i),
+			Link: fmt.Sprintf("%s/p/%d", server.URL, i),
+		})
+		if !result.Success || len(result.Images) != 1 {
+			t.Errorf("Product %d should see a clean session, got %+v", i, result)
+		}
+	}
+
+	if launches := s.Pool().Launches(); launches != 2 {
+		t.Errorf("Expected the browser to be restarted after 2 pages (2 launches), got %d", launches)
+	}
+}
+
+func TestBrowserPoolLaunchesInParallel(t *testing.T) {
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	// Browsers that never finish starting
+	script, _ := fakeChrome(t, tempDir, "exec sleep 30")
+	cfg.ChromePath = script
+	cfg.BrowserStartTimeout = time.Second
+	cfg.BrowserPoolSize = 2
+
+	pool := scraper.NewPool(cfg)
+	defer pool.Close()
+
+	// A slow start holds up only the workers of its own slot
+	started := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := pool.Tab(); err == nil {
+				t.Error("Expected the hanging browser to fail")
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(started); elapsed > 1800*time.Millisecond {
+		t.Errorf("Expected both browsers to start at the same time, took %v", elapsed)
+	}
+}
+
+func TestBrowserFlagsParsing(t *testing.T) {
+	t.Setenv("OUTPUT_DIR", t.TempDir())
+	t.Setenv("BROWSER_FLAGS", "--window-size=1920,1080, --proxy-server=http://proxy:8080,--no-sandbox=false")
+	cfg := config.Load()
+
+	want := []string{
+		"--headless", "--disable-gpu", "--no-sandbox", "--disable-dev-shm-usage",
+		"--window-size=1920,1080", "--proxy-server=http://proxy:8080", "--no-sandbox=false",
+	}
+	if !reflect.DeepEqual(cfg.BrowserFlags, want) {
+		t.Errorf("Expected flags %q, got %q", want, cfg.BrowserFlags)
+	}
+
+	t.Setenv("BROWSER_DEFAULT_FLAGS", "false")
+	t.Setenv("BROWSER_FLAGS", "--headless,--no-sandbox=false")
+	cfg = config.Load()
+	if want := []string{"--headless", "--no-sandbox=false"}; !reflect.DeepEqual(cfg.BrowserFlags, want) {
+		t.Errorf("Expected only the custom flags %q, got %q", want, cfg.BrowserFlags)
+	}
+	if !cfg.DisableDefaultBrowserFlags {
+		t.Error("Expected default flags to be disabled")
+	}
+}
+
+func TestScraperResultMetadata(t *testing.T) {
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.ChromePath = filepath.Join(tempDir, "no-such-chrome")
+
+	s, err := scraper.New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create scraper: %v", err)
+	}
+	defer s.Cleanup()
+
+	// Results carry the input metadata of their product, even when the
+	// browser cannot be started
+	product := models.Product{
+		ID:       "meta1",
+		Link:     "http://example.com/meta1",
+		Metadata: map[string]string{"brand": "Acme", "season": "SS25"},
+	}
+	result := s.ScrapeProduct(context.Background(), 1, product)
+	if result.Success || !reflect.DeepEqual(result.Metadata, product.Metadata) {
+		t.Errorf("Expected the failed result to carry the metadata, got %+v", result)
+	}
+}
+
+// fakeChrome writes a script that records its arguments to a file and then
+// runs the given shell command in place of Chrome
+func fakeChrome(t *testing.T, dir, then string) (string, string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Fake browser needs a POSIX shell")
+	}
+	script := filepath.Join(dir, "fake-chrome")
+	argsFile := filepath.Join(dir, "args.txt")
+	content := fmt.Sprintf("#!/bin/sh\nprintf '%%s\\n' \"$@\" > %q\n%s\n", argsFile, then)
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("Failed to write fake browser: %v", err)
+	}
+	return script, argsFile
+}
+
+func TestBrowserFlagsReachChrome(t *testing.T) {
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	script, argsFile := fakeChrome(t, tempDir, "exit 1")
+	cfg.ChromePath = script
+
+	launch := func() []string {
+		os.Remove(argsFile)
+		s, err := scraper.New(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create scraper: %v", err)
+		}
+		defer s.Cleanup()
+		result := s.ScrapeProduct(context.Background(), 1, models.Product{ID: "p1", Link: "https://example.com/p1"})
+		if result.Success {
+			t.Fatal("Expected the fake browser to fail")
+		}
+		data, err := os.ReadFile(argsFile)
+		if err != nil {
+			t.Fatalf("Fake browser was not launched: %v", err)
+		}
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+	contains := func(args []string, arg string) bool {
+		for _, a := range args {
+			if a == arg {
+				return true
+			}
+		}
+		return false
+	}
+
+	cfg.BrowserFlags = []string{"--window-size=1920,1080", "--proxy-server=http://proxy:8080", "--disable-gpu=false", "--mute-audio=true"}
+	args := launch()
+	for _, arg := range []string{"--window-size=1920,1080", "--proxy-server=http://proxy:8080", "--mute-audio", "--headless", "--no-first-run"} {
+		if !contains(args, arg) {
+			t.Errorf("Expected %s in browser arguments %q", arg, args)
+		}
+	}
+	if contains(args, "--disable-gpu") {
+		t.Errorf("Expected --disable-gpu to be removed, got %q", args)
+	}
+
+	cfg.DisableDefaultBrowserFlags = true
+	cfg.BrowserFlags = []string{"--lang=de-DE"}
+	args = launch()
+	if !contains(args, "--lang=de-DE") {
+		t.Errorf("Expected --lang=de-DE in browser arguments %q", args)
+	}
+	if contains(args, "--headless") || contains(args, "--no-first-run") {
+		t.Errorf("Expected no default flags, got %q", args)
+	}
+}
+
+func TestBrowserStartTimeout(t *testing.T) {
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	// A browser that never reports its DevTools address
+	script, _ := fakeChrome(t, tempDir, "exec sleep 30")
+	cfg.ChromePath = script
+	cfg.BrowserStartTimeout = 500 * time.Millisecond
+
+	s, err := scraper.New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create scraper: %v", err)
+	}
+	defer s.Cleanup()
+
+	started := time.Now()
+	result := s.ScrapeProduct(context.Background(), 1, models.Product{ID: "p1", Link: "https://example.com/p1"})
+	if result.Success {
+		t.Fatal("Expected the hanging browser to fail")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Expected the start timeout to stop the launch, took %v", elapsed)
+	}
+}
+
+func TestPageLoadDelay(t *testing.T) {
+	if !chromeAvailable() {
+		t.Skip("Chrome/Chromium not found")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><div id="js-product-images-container"><a data-slide-id="zoom" href="https://cdn.example.com/1.jpg">1</a></div></body></html>`)
+	}))
+	defer server.Close()
+
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.PageLoadDelay = 1500 * time.Millisecond
+
+	s, err := scraper.New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create scraper: %v", err)
+	}
+	defer s.Cleanup()
+
+	result := s.ScrapeProduct(context.Background(), 1, models.Product{ID: "p1", Link: server.URL})
+	if !result.Success {
+		t.Fatalf("Scrape failed: %s", result.Error)
+	}
+	if d := result.Attempts[0].DurationMS; d < 1500 {
+		t.Errorf("Expected the page load delay of 1500ms to be applied, attempt took %dms", d)
+	}
+
+	// A page load delay longer than the request timeout fails the page
+	cfg.RequestTimeout = time.Second
+	cfg.PageLoadDelay = 3 * time.Second
+	if result := s.ScrapeProduct(context.Background(), 1, models.Product{ID: "p2", Link: server.URL}); result.Success {
+		t.Error("Expected the request timeout to cut the page load delay short")
+	}
+}
+
+func TestSiteProfiles(t *testing.T) {
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+
+	dir := filepath.Join(tempDir, "profiles")
+	os.Mkdir(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "01-shop.yaml"), []byte(`
+name: shop
+hosts: ["shop.example.com", "*.shop.example.com"]
+wait_selector: ".gallery"
+image_selectors: [".gallery img", ".gallery source"]
+`), 0644)
+	os.WriteFile(filepath.Join(dir, "02-store.json"), []byte(`{
+  "hosts": ["*.example.com"],
+  "script": "Array.from(document.images).map(img => img.currentSrc)"
+}`), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a profile"), 0644)
+	cfg.SiteProfilesDir = dir
+
+	s, err := scraper.New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create scraper: %v", err)
+	}
+	defer s.Cleanup()
+
+	tests := []struct {
+		link, want string
+	}{
+		{"https://shop.example.com/p/1", "shop"},
+		{"https://de.shop.example.com:8443/p/1", "shop"},
+		{"https://STORE.example.com/p/1", "02-store"},
+		{"https://example.org/p/1", "default"},
+	}
+	for _, test := range tests {
+		if got := s.ProfileFor(test.link).Name; got != test.want {
+			t.Errorf("Expected profile %s for %s, got %s", test.want, test.link, got)
+		}
+	}
+	if shop := s.ProfileFor("https://shop.example.com/p/1"); shop.Attribute != "src" || len(shop.ImageSelectors) != 2 {
+		t.Errorf("Unexpected shop profile: %+v", shop)
+	}
+	if def := scraper.DefaultProfile(); def.WaitSelector != "#js-product-images-container" || def.Attribute != "href" {
+		t.Errorf("Unexpected default profile: %+v", def)
+	}
+
+	os.WriteFile(filepath.Join(dir, "03-broken.yaml"), []byte("hosts: [\"broken.example.com\"]\n"), 0644)
+	if _, err := scraper.New(cfg); err == nil {
+		t.Error("Expected an error for a profile without image selectors or script")
+	}
+}
+
+func TestSiteProfileExtraction(t *testing.T) {
+	if !chromeAvailable() {
+		t.Skip("Chrome/Chromium not found")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><div class="gallery">
+			<img data-full="https://cdn.example.com/1.jpg">
+			<img data-full="http://cdn.example.com/2.jpg">
+			<img data-full="/relative.jpg">
+			<img data-full="//cdn.example.com/3.jpg">
+			<img>
+		</div></body></html>`)
+	}))
+	defer server.Close()
+
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.PageLoadDelay = 0
+	cfg.SiteProfilesDir = tempDir
+	os.WriteFile(filepath.Join(tempDir, "local.yaml"), []byte(`
+hosts: ["127.0.0.1"]
+wait_selector: ".gallery"
+image_selectors: [".gallery img"]
+attribute: data-full
+allow_http: true
+`), 0644)
+
+	s, err := scraper.New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create scraper: %v", err)
+	}
+	defer s.Cleanup()
+
+	result := s.ScrapeProduct(context.Background(), 1, models.Product{ID: "p1", Link: server.URL})
+	// Relative and protocol-relative URLs are resolved against the page
+	want := []string{"https://cdn.example.com/1.jpg", "http://cdn.example.com/2.jpg", server.URL + "/relative.jpg", "http://cdn.example.com/3.jpg"}
+	if !result.Success || !reflect.DeepEqual(result.Images, want) {
+		t.Errorf("Expected images %v, got %+v", want, result)
+	}
+}
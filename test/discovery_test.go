@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/product-scraper/internal/discovery"
+	"github.com/product-scraper/internal/models"
+)
+
+func TestSitemapDiscovery(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>%[1]s/sitemap-products.xml</loc></sitemap>
+  <sitemap><loc>%[1]s/sitemap-more.xml.gz</loc></sitemap>
+  <sitemap><loc>%[1]s/sitemap.xml</loc></sitemap>
+</sitemapindex>`, server.URL)
+	})
+	mux.HandleFunc("/sitemap-products.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:image="http://www.google.com/schemas/sitemap-image/1.1">
+  <url>
+    <loc>https://shop.example.com/p/1001-runner</loc>
+    <image:image><image:loc>https://shop.example.com/p/1001-runner.jpg</image:loc></image:image>
+  </url>
+  <url><loc>https://shop.example.com/c/shoes</loc></url>
+  <url><loc> https://shop.example.com/p/1002-boot </loc><lastmod>2024-01-01</lastmod></url>
+</urlset>`)
+	})
+	mux.HandleFunc("/sitemap-more.xml.gz", func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		fmt.Fprint(gz, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://shop.example.com/p/1003-sandal</loc></url>
+</urlset>`)
+		gz.Close()
+		w.Header().Set("Content-Type", "application/x-gzip")
+		w.Write(buf.Bytes())
+	})
+
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.SitemapURL = server.URL + "/sitemap.xml"
+	cfg.SitemapURLPattern = `/p/`
+	cfg.SitemapIDPattern = `/p/(\d+)`
+
+	sitemap, err := discovery.NewSitemap(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create sitemap source: %v", err)
+	}
+
+	var products []models.Product
+	err = sitemap.Stream(context.Background(), func(p models.Product) error {
+		products = append(products, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to stream sitemap: %v", err)
+	}
+
+	var ids, links []string
+	for _, p := range products {
+		ids = append(ids, p.ID)
+		links = append(links, p.Link)
+	}
+	if !reflect.DeepEqual(ids, []string{"1001", "1002", "1003"}) {
+		t.Errorf("Unexpected IDs: %v", ids)
+	}
+	if links[1] != "https://shop.example.com/p/1002-boot" {
+		t.Errorf("Expected trimmed link, got %q", links[1])
+	}
+
+	// Without an ID pattern the last path segment is used
+	cfg.SitemapURL = server.URL + "/sitemap-products.xml"
+	cfg.SitemapIDPattern = ""
+	sitemap, err = discovery.NewSitemap(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create sitemap source: %v", err)
+	}
+	products = nil
+	sitemap.Stream(context.Background(), func(p models.Product) error {
+		products = append(products, p)
+		return nil
+	})
+	if len(products) != 2 || products[0].ID != "1001-runner" {
+		t.Errorf("Unexpected products without ID pattern: %+v", products)
+	}
+}
+
+func TestCategoryCrawling(t *testing.T) {
+	if !chromeAvailable() {
+		t.Skip("Chrome/Chromium not found")
+	}
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/c/shoes", func(w http.ResponseWriter, r *http.Request) {
+		next := `<a rel="next" href="/c/shoes?page=2">Next</a>`
+		items := `<div data-sku="S1"><a class="product" href="/p/runner">Runner</a></div>
+			<div data-sku="S2"><a class="product" href="/p/boot">Boot</a></div>`
+		if r.URL.Query().Get("page") == "2" {
+			next = ""
+			items = `<div data-sku="S3"><a class="product" href="/p/sandal">Sandal</a></div>
+				<div data-sku="S1"><a class="product" href="/p/runner">Runner</a></div>`
+		}
+		fmt.Fprintf(w, `<html><body><nav><a href="/about">About</a></nav>%s%s</body></html>`, items, next)
+	})
+
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.RequestTimeout = 30 * time.Second
+	cfg.PageLoadDelay = 100 * time.Millisecond
+	cfg.CategoryURLs = []string{server.URL + "/c/shoes"}
+	cfg.CategoryLinkSelector = "a.product"
+	cfg.CategoryIDAttribute = "data-sku"
+	cfg.CategoryNextSelector = "a[rel=next]"
+	cfg.CategoryMaxPages = 5
+
+	crawler, err := discovery.NewCategoryCrawler(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create category crawler: %v", err)
+	}
+
+	var ids []string
+	err = crawler.Stream(context.Background(), func(p models.Product) error {
+		ids = append(ids, p.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to crawl category: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"S1", "S2", "S3"}) {
+		t.Errorf("Unexpected IDs: %v", ids)
+	}
+}
+
+// chromeAvailable reports whether a Chrome binary chromedp can launch is installed
+func chromeAvailable() bool {
+	for _, name := range []string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser", "headless-shell"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
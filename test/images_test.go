@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/product-scraper/internal/images"
+	"github.com/product-scraper/internal/models"
+)
+
+func TestImageDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("image-bytes-"), 1000)
+	sum := sha256.Sum256(content)
+
+	var mutex sync.Mutex
+	var requests, ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests = append(requests, r.URL.Path)
+		if rng := r.Header.Get("Range"); rng != "" {
+			ranges = append(ranges, rng)
+		}
+		mutex.Unlock()
+
+		switch r.URL.Path {
+		case "/a.jpg", "/b":
+			w.Header().Set("Content-Type", "image/png")
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.ImageDir = filepath.Join(tempDir, "output", "images")
+	cfg.ImagePathLayout = "{id}/{index}.{ext}"
+	cfg.ImageDownloadWorkers = 2
+	cfg.MaxRetries = 2
+	cfg.RetryDelay = 0
+
+	downloader, err := images.NewDownloader(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create downloader: %v", err)
+	}
+
+	// A partial download left by an interrupted run is resumed with a range request
+	partPath := downloader.Path("SKU/1", 2, ".part")
+	os.MkdirAll(filepath.Dir(partPath), 0755)
+	os.WriteFile(partPath, content[:5000], 0644)
+	os.WriteFile(partPath+".url", []byte(server.URL+"/b"), 0644)
+
+	result := downloader.Download(context.Background(), models.ProductResult{
+		ID:      "SKU/1",
+		Success: true,
+		Images:  []string{server.URL + "/a.jpg", server.URL + "/b", server.URL + "/missing.jpg"},
+	})
+
+	if len(result.Files) != 3 {
+		t.Fatalf("Expected 3 files, got %+v", result.Files)
+	}
+	for i, file := range result.Files[:2] {
+		if file.Error != "" {
+			t.Fatalf("Download %d failed: %s", i+1, file.Error)
+		}
+		want := filepath.Join(cfg.ImageDir, "SKU_1", []string{"1.png", "2.png"}[i])
+		if file.Path != want {
+			t.Errorf("Expected path %s, got %s", want, file.Path)
+		}
+		if file.Size != int64(len(content)) || file.ContentType != "image/png" || file.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("Unexpected file details: %+v", file)
+		}
+		data, _ := os.ReadFile(file.Path)
+		if !bytes.Equal(data, content) {
+			t.Errorf("Downloaded file %d does not match the image", i+1)
+		}
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=5000-" {
+		t.Errorf("Expected the partial download to be resumed, got ranges %v", ranges)
+	}
+	if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+		t.Errorf("Partial file should be gone after completing the download")
+	}
+
+	missing := result.Files[2]
+	if missing.Error == "" || missing.Path != "" || missing.URL != server.URL+"/missing.jpg" {
+		t.Errorf("Expected a failed download for the missing image, got %+v", missing)
+	}
+
+	// Completed downloads are reused; only the missing image is requested again
+	requests = nil
+	again := downloader.Download(context.Background(), models.ProductResult{
+		ID:     "SKU/1",
+		Images: []string{server.URL + "/a.jpg", server.URL + "/b", server.URL + "/missing.jpg"},
+	})
+	for _, path := range requests {
+		if path != "/missing.jpg" {
+			t.Errorf("Unexpected request for %s", path)
+		}
+	}
+	if again.Files[0].SHA256 != result.Files[0].SHA256 {
+		t.Errorf("Reused file should keep its hash")
+	}
+}
+
+func TestImageDownloadReordered(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("image "+r.URL.Path))
+	}))
+	defer server.Close()
+
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.ImageDir = filepath.Join(tempDir, "output", "images")
+	cfg.ImagePathLayout = "{id}/{index}.{ext}"
+
+	downloader, err := images.NewDownloader(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create downloader: %v", err)
+	}
+	download := func(paths ...string) models.ProductResult {
+		result := models.ProductResult{ID: "p1", Success: true}
+		for _, path := range paths {
+			result.Images = append(result.Images, server.URL+path)
+		}
+		return downloader.Download(context.Background(), result)
+	}
+
+	download("/a.jpg", "/b.jpg")
+
+	// A partial download of another image is started over
+	partPath := downloader.Path("p1", 3, ".part")
+	os.WriteFile(partPath, []byte("image /d"), 0644)
+	os.WriteFile(partPath+".url", []byte(server.URL+"/d.jpg"), 0644)
+
+	// The site now lists the images in a different order
+	result := download("/b.jpg", "/a.jpg", "/c.jpg")
+	for i, path := range []string{"/b.jpg", "/a.jpg", "/c.jpg"} {
+		file := result.Files[i]
+		data, err := os.ReadFile(file.Path)
+		if err != nil || string(data) != "image "+path {
+			t.Errorf("Expected %s in %s, got %q (%s)", path, file.Path, data, file.Error)
+		}
+	}
+}
+
+func TestImageDownloadStage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("jpeg"))
+	}))
+	defer server.Close()
+
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.ImageDir = filepath.Join(tempDir, "output", "images")
+	cfg.ImagePathLayout = "{id}-{index}.{ext}"
+	cfg.ImageDownloadWorkers = 3
+
+	in := make(chan models.ProductResult, 10)
+	out := make(chan models.ProductResult, 10)
+	for _, id := range []string{"p1", "p2", "p3"} {
+		in <- models.ProductResult{ID: id, Success: true, Images: []string{server.URL + "/" + id + ".jpg"}}
+	}
+	in <- models.ProductResult{ID: "p4", Error: "timeout"}
+	close(in)
+
+	downloader, err := images.NewDownloader(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create downloader: %v", err)
+	}
+	downloader.Run(context.Background(), in, out)
+
+	count := 0
+	for result := range out {
+		count++
+		if !result.Success {
+			if len(result.Files) != 0 {
+				t.Errorf("Failed results should pass through untouched: %+v", result)
+			}
+			continue
+		}
+		if len(result.Files) != 1 || result.Files[0].Path != filepath.Join(cfg.ImageDir, result.ID+"-1.jpg") {
+			t.Errorf("Unexpected files for %s: %+v", result.ID, result.Files)
+		}
+	}
+	if count != 4 {
+		t.Errorf("Expected 4 results, got %d", count)
+	}
+}
+
+func TestImageStore(t *testing.T) {
+	shared := []byte("size chart")
+	var mutex sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests[r.URL.Path]++
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "image/jpeg")
+		switch r.URL.Path {
+		case "/chart.jpg", "/chart-copy.jpg":
+			w.Write(shared)
+		default:
+			w.Write([]byte("product " + r.URL.Path))
+		}
+	}))
+	defer server.Close()
+
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.ImageDir = filepath.Join(tempDir, "output", "images")
+	cfg.ImageManifestFile = filepath.Join(tempDir, "output", "images", "manifest.json")
+	cfg.ImageStorage = "hash"
+	cfg.ImageDownloadWorkers = 1
+
+	downloader, err := images.NewDownloader(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create downloader: %v", err)
+	}
+
+	in := make(chan models.ProductResult, 2)
+	out := make(chan models.ProductResult, 2)
+	in <- models.ProductResult{ID: "p1", Success: true, Images: []string{server.URL + "/p1.jpg", server.URL + "/chart.jpg"}}
+	in <- models.ProductResult{ID: "p2", Success: true, Images: []string{server.URL + "/chart-copy.jpg", server.URL + "/chart.jpg"}}
+	close(in)
+	downloader.Run(context.Background(), in, out)
+
+	results := make(map[string]models.ProductResult)
+	for result := range out {
+		results[result.ID] = result
+	}
+
+	sum := sha256.Sum256(shared)
+	chartHash := hex.EncodeToString(sum[:])
+	chart := results["p1"].Files[1]
+	if chart.SHA256 != chartHash || chart.Path != filepath.Join(cfg.ImageDir, "objects", chartHash[:2], chartHash+".jpg") {
+		t.Errorf("Unexpected stored image: %+v", chart)
+	}
+	for _, file := range results["p2"].Files {
+		if file.SHA256 != chartHash || file.Path != chart.Path {
+			t.Errorf("Identical images should share one object, got %+v", file)
+		}
+	}
+	if results["p2"].Files[0].URL != server.URL+"/chart-copy.jpg" {
+		t.Errorf("Stored images should keep their original URL")
+	}
+	if requests["/chart.jpg"] != 1 {
+		t.Errorf("Expected the shared URL to be downloaded once, got %d", requests["/chart.jpg"])
+	}
+
+	var objects int
+	filepath.Walk(filepath.Join(cfg.ImageDir, "objects"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			objects++
+		}
+		return nil
+	})
+	if objects != 2 {
+		t.Errorf("Expected 2 stored objects, got %d", objects)
+	}
+
+	data, err := os.ReadFile(cfg.ImageManifestFile)
+	if err != nil {
+		t.Fatalf("Manifest was not saved: %v", err)
+	}
+	var manifest models.ImageManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("Invalid manifest: %v", err)
+	}
+	if len(manifest.Products["p2"]) != 2 || manifest.Products["p2"][1].SHA256 != chartHash || len(manifest.Objects) != 2 {
+		t.Errorf("Unexpected manifest: %+v", manifest)
+	}
+
+	// A later run finds the images in the manifest
+	downloader, err = images.NewDownloader(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	again := downloader.Download(context.Background(), models.ProductResult{ID: "p3", Images: []string{server.URL + "/chart.jpg"}})
+	if again.Files[0].Path != chart.Path || requests["/chart.jpg"] != 1 {
+		t.Errorf("Expected the stored image to be reused")
+	}
+}
+
+func TestImageStoreConcurrentDownloads(t *testing.T) {
+	content := bytes.Repeat([]byte("shared-"), 10000)
+	var mutex sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests++
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "image/jpeg")
+		// Write slowly so that the downloads overlap
+		for i := 0; i < len(content); i += 7000 {
+			w.Write(content[i:min(i+7000, len(content))])
+			w.(http.Flusher).Flush()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.ImageDir = filepath.Join(tempDir, "output", "images")
+	cfg.ImageManifestFile = filepath.Join(tempDir, "output", "images", "manifest.json")
+	cfg.ImageStorage = "hash"
+
+	downloader, err := images.NewDownloader(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create downloader: %v", err)
+	}
+
+	sum := sha256.Sum256(content)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result := downloader.Download(context.Background(), models.ProductResult{
+				ID:     fmt.Sprintf("p%d", i),
+				Images: []string{server.URL + "/chart.jpg"},
+			})
+			if file := result.Files[0]; file.Error != "" || file.SHA256 != hex.EncodeToString(sum[:]) {
+				t.Errorf("Unexpected file for product %d: %+v", i, file)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if requests != 1 {
+		t.Errorf("Expected the shared URL to be downloaded once, got %d", requests)
+	}
+}
+
+// testPhoto draws a synthetic photo at the given size; the same scene at
+// different sizes should hash alike
+func testPhoto(w, h int, inverted bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			v := uint8(255 * (fx*fx + fy) / 2)
+			if fx > 0.3 && fx < 0.6 && fy > 0.2 && fy < 0.5 {
+				v = 230
+			}
+			if inverted {
+				v = uint8(255 * (1 - fy*fy + fx) / 2)
+			}
+			img.Set(x, y, color.RGBA{R: v, G: v / 2, B: 255 - v, A: 255})
+		}
+	}
+	return img
+}
+
+func TestImageNearDuplicates(t *testing.T) {
+	encode := func(img image.Image) []byte {
+		var buf bytes.Buffer
+		jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+		return buf.Bytes()
+	}
+	photos := map[string][]byte{
+		"/small.jpg": encode(testPhoto(200, 150, false)),
+		"/other.jpg": encode(testPhoto(400, 300, true)),
+		"/large.jpg": encode(testPhoto(800, 600, false)),
+	}
+
+	small, _ := jpeg.Decode(bytes.NewReader(photos["/small.jpg"]))
+	large, _ := jpeg.Decode(bytes.NewReader(photos["/large.jpg"]))
+	other, _ := jpeg.Decode(bytes.NewReader(photos["/other.jpg"]))
+	if d := images.Distance(images.DHash(small), images.DHash(large)); d > 6 {
+		t.Errorf("Resized copies should hash alike, distance %d", d)
+	}
+	if d := images.Distance(images.DHash(small), images.DHash(other)); d <= 6 {
+		t.Errorf("Different photos should hash apart, distance %d", d)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(photos[r.URL.Path])
+	}))
+	defer server.Close()
+
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.ImageDir = filepath.Join(tempDir, "output", "images")
+	cfg.ImagePathLayout = "{id}/{index}.{ext}"
+	cfg.DedupImages = true
+	cfg.ImageDuplicateThreshold = 6
+
+	downloader, err := images.NewDownloader(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create downloader: %v", err)
+	}
+	result := downloader.Download(context.Background(), models.ProductResult{
+		ID:      "p1",
+		Success: true,
+		Images:  []string{server.URL + "/small.jpg?w=200", server.URL + "/other.jpg", server.URL + "/large.jpg"},
+	})
+
+	want := []string{server.URL + "/large.jpg", server.URL + "/other.jpg"}
+	if len(result.Images) != 2 || result.Images[0] != want[0] || result.Images[1] != want[1] {
+		t.Fatalf("Expected the large copy in place of the small one, got %v", result.Images)
+	}
+	if len(result.Files) != 2 || result.Files[0].Width != 800 || result.Files[0].PHash == "" {
+		t.Errorf("Unexpected files: %+v", result.Files)
+	}
+	if len(result.DroppedImages) != 1 {
+		t.Fatalf("Expected 1 dropped image, got %+v", result.DroppedImages)
+	}
+	dropped := result.DroppedImages[0]
+	if dropped.URL != server.URL+"/small.jpg?w=200" || dropped.DuplicateOf != want[0] || dropped.Width != 200 {
+		t.Errorf("Unexpected dropped image: %+v", dropped)
+	}
+
+	// The manifest of the content-addressed store lists only the kept images
+	cfg.ImageStorage = "hash"
+	cfg.ImageManifestFile = filepath.Join(tempDir, "output", "images", "manifest.json")
+	downloader, err = images.NewDownloader(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create downloader: %v", err)
+	}
+	in := make(chan models.ProductResult, 1)
+	out := make(chan models.ProductResult, 1)
+	in <- models.ProductResult{
+		ID:      "p1",
+		Success: true,
+		Images:  []string{server.URL + "/small.jpg?w=200", server.URL + "/other.jpg", server.URL + "/large.jpg"},
+	}
+	close(in)
+	downloader.Run(context.Background(), in, out)
+
+	data, err := os.ReadFile(cfg.ImageManifestFile)
+	if err != nil {
+		t.Fatalf("Manifest was not saved: %v", err)
+	}
+	var manifest models.ImageManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("Invalid manifest: %v", err)
+	}
+	refs := manifest.Products["p1"]
+	if len(refs) != 2 || refs[0].URL != want[0] || refs[1].URL != want[1] {
+		t.Errorf("Expected the manifest to list the kept images %v, got %+v", want, refs)
+	}
+}
+
+func TestImageValidation(t *testing.T) {
+	encode := func(w, h int) []byte {
+		var buf bytes.Buffer
+		jpeg.Encode(&buf, testPhoto(w, h, false), nil)
+		return buf.Bytes()
+	}
+	photo, thumb := encode(400, 300), encode(60, 40)
+	// 1x1 transparent GIF, as served by tracking pixels
+	pixel := []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\xff\xff\xff!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/photo.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(photo)
+		case "/thumb.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(thumb)
+		case "/pixel.gif":
+			w.Header().Set("Content-Type", "image/gif")
+			w.Write(pixel)
+		case "/page.jpg":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.ImageMinWidth = 100
+	cfg.ImageMinHeight = 100
+
+	product := models.ProductResult{
+		ID:      "p1",
+		Success: true,
+		Images: []string{
+			server.URL + "/photo.jpg",
+			server.URL + "/pixel.gif",
+			server.URL + "/missing.jpg",
+			server.URL + "/thumb.jpg",
+			server.URL + "/page.jpg",
+		},
+	}
+	wantReasons := []string{images.ReasonTooSmall, images.ReasonBadStatus, images.ReasonTooSmall, images.ReasonNotImage}
+
+	for _, mode := range []string{"remove", "flag"} {
+		cfg.ImageValidationMode = mode
+		validator, err := images.NewValidator(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create validator: %v", err)
+		}
+		result := validator.Validate(context.Background(), product)
+
+		if mode == "remove" && (len(result.Images) != 1 || result.Images[0] != server.URL+"/photo.jpg") {
+			t.Errorf("Expected only the photo to be kept, got %v", result.Images)
+		}
+		if mode == "flag" && len(result.Images) != len(product.Images) {
+			t.Errorf("Expected flagged images to be kept, got %v", result.Images)
+		}
+		if len(result.ImageIssues) != len(wantReasons) {
+			t.Fatalf("Expected %d issues in %s mode, got %+v", len(wantReasons), mode, result.ImageIssues)
+		}
+		for i, issue := range result.ImageIssues {
+			if issue.Reason != wantReasons[i] || issue.Removed != (mode == "remove") {
+				t.Errorf("Unexpected issue %d in %s mode: %+v", i, mode, issue)
+			}
+		}
+		if pixel := result.ImageIssues[0]; pixel.Width != 1 || pixel.Height != 1 {
+			t.Errorf("Expected the pixel's size to be recorded, got %+v", pixel)
+		}
+	}
+
+	cfg.ImageValidationMode = "drop"
+	if _, err := images.NewValidator(cfg); err == nil {
+		t.Error("Expected an error for an unknown validation mode")
+	}
+}
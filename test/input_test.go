@@ -0,0 +1,435 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/product-scraper/internal/models"
+	"github.com/product-scraper/internal/utils"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestCSVProductLoading(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Semicolon separated, UTF-8 with BOM, aliased headers
+	csvFile := filepath.Join(tempDir, "products.csv")
+	content := "\xEF\xBB\xBFSKU;Name;PDP_URL\n" +
+		"A1;\"Shoe; red\";https://example.com/a1\n" +
+		"A2;Missing link;\n" +
+		"A3;Boot;https://example.com/a3\n"
+	if err := os.WriteFile(csvFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write CSV file: %v", err)
+	}
+
+	products, err := utils.LoadProducts(csvFile, utils.InputOptions{})
+	if err != nil {
+		t.Fatalf("Failed to load CSV file: %v", err)
+	}
+	if len(products) != 2 {
+		t.Fatalf("Expected 2 products, got %d", len(products))
+	}
+	if products[0].ID != "A1" || products[0].Link != "https://example.com/a1" {
+		t.Errorf("Unexpected first product: %+v", products[0])
+	}
+	if products[0].Metadata["Name"] != "Shoe; red" {
+		t.Errorf("Expected Name metadata to be carried through, got %v", products[0].Metadata)
+	}
+
+	// Tab separated, UTF-16LE with BOM as written by Excel "Unicode Text"
+	tsvFile := filepath.Join(tempDir, "products.txt")
+	units := utf16.Encode([]rune("id\turl\r\nB1\thttps://example.com/b1\r\n"))
+	data := []byte{0xFF, 0xFE}
+	for _, u := range units {
+		data = append(data, byte(u), byte(u>>8))
+	}
+	if err := os.WriteFile(tsvFile, data, 0644); err != nil {
+		t.Fatalf("Failed to write TSV file: %v", err)
+	}
+
+	products, err = utils.LoadProducts(tsvFile, utils.InputOptions{})
+	if err != nil {
+		t.Fatalf("Failed to load UTF-16 TSV file: %v", err)
+	}
+	if len(products) != 1 || products[0].ID != "B1" || products[0].Link != "https://example.com/b1" {
+		t.Errorf("Unexpected products from UTF-16 TSV: %+v", products)
+	}
+}
+
+func TestJSONProductLoading(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// A JSON array of products round-trips through the loader; Row is the
+	// record number within the array
+	original := []models.Product{
+		{ID: "00123", Link: "https://example.com/p/00123", Metadata: map[string]string{"brand": "Acme"}, Row: 1},
+		{ID: "456", Link: "https://example.com/p/456", Row: 2},
+	}
+	data, err := json.Marshal(original)
+	if err != nil {
+		t.Fatalf("Failed to marshal products: %v", err)
+	}
+	jsonFile := filepath.Join(tempDir, "products.json")
+	if err := os.WriteFile(jsonFile, data, 0644); err != nil {
+		t.Fatalf("Failed to write JSON file: %v", err)
+	}
+
+	products, err := utils.LoadProducts(jsonFile, utils.InputOptions{})
+	if err != nil {
+		t.Fatalf("Failed to load JSON file: %v", err)
+	}
+	if !reflect.DeepEqual(products, original) {
+		t.Errorf("Expected %+v, got %+v", original, products)
+	}
+
+	// Newline-delimited FailedURL records use "url" rather than "link"
+	jsonlFile := filepath.Join(tempDir, "failed.jsonl")
+	content := `{"id": 789, "url": "https://example.com/p/789", "error": "timeout", "attempts": 2}` + "\n\n" +
+		`{"id": "790", "url": "https://example.com/p/790"}` + "\n"
+	if err := os.WriteFile(jsonlFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write JSONL file: %v", err)
+	}
+
+	products, err = utils.LoadProducts(jsonlFile, utils.InputOptions{})
+	if err != nil {
+		t.Fatalf("Failed to load JSONL file: %v", err)
+	}
+	if len(products) != 2 || products[0].ID != "789" || products[0].Link != "https://example.com/p/789" {
+		t.Errorf("Unexpected products from JSONL: %+v", products)
+	}
+}
+
+func TestExcelSheetAndColumnMapping(t *testing.T) {
+	xlsxFile := filepath.Join(t.TempDir(), "merchant.xlsx")
+
+	f := excelize.NewFile()
+	f.SetSheetName("Sheet1", "Notes")
+	f.SetCellValue("Notes", "A1", "Exported by merchant team")
+	// Title rows above the header, non-standard header names
+	f.NewSheet("Shoes")
+	f.SetSheetRow("Shoes", "A1", &[]interface{}{"Spring catalogue"})
+	f.SetSheetRow("Shoes", "A3", &[]interface{}{"Article", "Title", "Web page"})
+	f.SetSheetRow("Shoes", "A4", &[]interface{}{"S1", "Runner", "https://example.com/s1"})
+	f.NewSheet("Boots")
+	f.SetSheetRow("Boots", "A3", &[]interface{}{"Article", "Title", "Web page"})
+	f.SetSheetRow("Boots", "A4", &[]interface{}{"B1", "Hiker", "https://example.com/b1"})
+	if err := f.SaveAs(xlsxFile); err != nil {
+		t.Fatalf("Failed to save Excel file: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts utils.InputOptions
+		ids  []string
+	}{
+		{"sheet by name", utils.InputOptions{Sheet: "boots", IDColumn: "Article", LinkColumn: "Web page", HeaderRow: 3}, []string{"B1"}},
+		{"sheet by index", utils.InputOptions{Sheet: "2", IDColumn: "Article", LinkColumn: "C", HeaderRow: 3}, []string{"S1"}},
+		{"all sheets", utils.InputOptions{AllSheets: true, IDColumn: "A", LinkColumn: "C", HeaderRow: 3}, []string{"S1", "B1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			products, err := utils.LoadProductsFromExcel(xlsxFile, tt.opts)
+			if err != nil {
+				t.Fatalf("Failed to load products: %v", err)
+			}
+			var ids []string
+			for _, p := range products {
+				ids = append(ids, p.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.ids) {
+				t.Errorf("Expected IDs %v, got %v", tt.ids, ids)
+			}
+		})
+	}
+
+	if _, err := utils.LoadProductsFromExcel(xlsxFile, utils.InputOptions{Sheet: "Hats"}); err == nil {
+		t.Errorf("Expected an error for a missing sheet")
+	}
+
+	// A short header name is not read as a column letter beyond the header,
+	// so sheets without the column are skipped instead of yielding rows
+	// without IDs
+	eanFile := filepath.Join(t.TempDir(), "ean.xlsx")
+	f = excelize.NewFile()
+	f.SetSheetRow("Sheet1", "A1", &[]interface{}{"Title", "Web page"})
+	f.SetSheetRow("Sheet1", "A2", &[]interface{}{"Runner", "https://example.com/s1"})
+	f.NewSheet("Hats")
+	f.SetSheetRow("Hats", "A1", &[]interface{}{"EAN", "Web page"})
+	f.SetSheetRow("Hats", "A2", &[]interface{}{"4006381333931", "https://example.com/h1"})
+	if err := f.SaveAs(eanFile); err != nil {
+		t.Fatalf("Failed to save Excel file: %v", err)
+	}
+	var ids []string
+	err := utils.StreamProductsFromExcel(eanFile, utils.InputOptions{AllSheets: true, IDColumn: "EAN", LinkColumn: "Web page"}, func(p models.Product) error {
+		ids = append(ids, p.ID)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(ids, []string{"4006381333931"}) {
+		t.Errorf("Expected only the EAN sheet to be read, got %v (%v)", ids, err)
+	}
+	if _, err := utils.LoadProductsFromExcel(eanFile, utils.InputOptions{IDColumn: "EAN", LinkColumn: "Web page"}); err == nil {
+		t.Errorf("Expected an error for a missing EAN column")
+	}
+}
+
+func TestExcelHyperlinkTargets(t *testing.T) {
+	xlsxFile := filepath.Join(t.TempDir(), "links.xlsx")
+
+	f := excelize.NewFile()
+	f.SetSheetRow("Sheet1", "A1", &[]interface{}{"id", "link"})
+	f.SetSheetRow("Sheet1", "A2", &[]interface{}{"h1", "View"})
+	if err := f.SetCellHyperLink("Sheet1", "B2", "https://example.com/h1", "External"); err != nil {
+		t.Fatalf("Failed to set hyperlink: %v", err)
+	}
+	f.SetCellValue("Sheet1", "A3", "h2")
+	if err := f.SetCellFormula("Sheet1", "B3", `HYPERLINK("https://example.com/h2","Red Shoe")`); err != nil {
+		t.Fatalf("Failed to set formula: %v", err)
+	}
+	f.SetSheetRow("Sheet1", "A4", &[]interface{}{"h3", "https://example.com/h3"})
+	if err := f.SaveAs(xlsxFile); err != nil {
+		t.Fatalf("Failed to save Excel file: %v", err)
+	}
+
+	products, err := utils.LoadProductsFromExcel(xlsxFile, utils.InputOptions{})
+	if err != nil {
+		t.Fatalf("Failed to load products: %v", err)
+	}
+
+	expected := map[string]string{
+		"h1": "https://example.com/h1",
+		"h2": "https://example.com/h2",
+		"h3": "https://example.com/h3",
+	}
+	if len(products) != len(expected) {
+		t.Fatalf("Expected %d products, got %d: %+v", len(expected), len(products), products)
+	}
+	for _, p := range products {
+		if expected[p.ID] != p.Link {
+			t.Errorf("Expected link %s for %s, got %s", expected[p.ID], p.ID, p.Link)
+		}
+	}
+}
+
+func TestExcelStreaming(t *testing.T) {
+	xlsxFile := filepath.Join(t.TempDir(), "large.xlsx")
+
+	f := excelize.NewFile()
+	sw, err := f.NewStreamWriter("Sheet1")
+	if err != nil {
+		t.Fatalf("Failed to create stream writer: %v", err)
+	}
+	sw.SetRow("A1", []interface{}{"id", "link"})
+	for i := 2; i <= 5000; i++ {
+		cell, _ := excelize.CoordinatesToCellName(1, i)
+		sw.SetRow(cell, []interface{}{fmt.Sprintf("P%d", i), fmt.Sprintf("https://example.com/p/%d", i)})
+	}
+	if err := sw.Flush(); err != nil {
+		t.Fatalf("Failed to flush stream writer: %v", err)
+	}
+	if err := f.SaveAs(xlsxFile); err != nil {
+		t.Fatalf("Failed to save Excel file: %v", err)
+	}
+
+	// The handler sees products in sheet order and can stop the read early
+	errStop := errors.New("stop")
+	var ids []string
+	err = utils.StreamProducts(xlsxFile, utils.InputOptions{}, func(p models.Product) error {
+		ids = append(ids, p.ID)
+		if len(ids) == 10 {
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("Expected the handler error to be returned, got %v", err)
+	}
+	if len(ids) != 10 || ids[0] != "P2" || ids[9] != "P11" {
+		t.Errorf("Unexpected streamed IDs: %v", ids)
+	}
+}
+
+func TestInputValidation(t *testing.T) {
+	csvFile := filepath.Join(t.TempDir(), "products.csv")
+	content := "id,link,brand\n" +
+		"v1,https://example.com/v1,Acme\n" +
+		",https://example.com/no-id,Acme\n" +
+		"v3,,Acme\n" +
+		"v4,ftp://example.com/v4,Acme\n" +
+		"v5,example.com/v5,Acme\n" +
+		"\n" +
+		"v1,https://example.com/v1-again,Acme\n" +
+		"v7,https://example.com/v1,Acme\n"
+	if err := os.WriteFile(csvFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write CSV file: %v", err)
+	}
+
+	validator := utils.NewValidator(csvFile)
+	var accepted []string
+	err := utils.StreamProducts(csvFile, utils.InputOptions{}, func(p models.Product) error {
+		if validator.Check(p) {
+			accepted = append(accepted, p.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to stream products: %v", err)
+	}
+
+	if !reflect.DeepEqual(accepted, []string{"v1", "v1", "v7"}) {
+		t.Errorf("Unexpected accepted products: %v", accepted)
+	}
+
+	report := validator.Report()
+	if report.TotalRows != 7 || report.ValidRows != 3 {
+		t.Errorf("Expected 7 rows and 3 valid, got %d and %d", report.TotalRows, report.ValidRows)
+	}
+
+	skipped := map[int]string{}
+	for _, issue := range report.Skipped {
+		skipped[issue.Row] = issue.Reason
+	}
+	expected := map[int]string{
+		3: utils.ReasonMissingID,
+		4: utils.ReasonMissingLink,
+		5: utils.ReasonUnsupportedScheme,
+		6: utils.ReasonMalformedURL,
+	}
+	if !reflect.DeepEqual(skipped, expected) {
+		t.Errorf("Expected skipped rows %v, got %v", expected, skipped)
+	}
+
+	suspicious := map[int]string{}
+	for _, issue := range report.Suspicious {
+		suspicious[issue.Row] = issue.Reason
+	}
+	if !reflect.DeepEqual(suspicious, map[int]string{8: utils.ReasonDuplicateID, 9: utils.ReasonDuplicateURL}) {
+		t.Errorf("Unexpected suspicious rows: %v", suspicious)
+	}
+}
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"HTTPS://Shop.Example.COM/p/123/", "https://shop.example.com/p/123"},
+		{"https://shop.example.com:443/p/123?utm_source=mail&utm_medium=x#reviews", "https://shop.example.com/p/123"},
+		{"https://shop.example.com/p/123?size=9&gclid=abc&color=red", "https://shop.example.com/p/123?color=red&size=9"},
+		{"http://shop.example.com", "http://shop.example.com/"},
+		{"http://shop.example.com:8080/P/Case/", "http://shop.example.com:8080/P/Case"},
+	}
+	for _, tt := range tests {
+		got, err := utils.CanonicalURL(tt.in)
+		if err != nil {
+			t.Errorf("CanonicalURL(%q) returned error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.out {
+			t.Errorf("CanonicalURL(%q) = %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestDeduplicator(t *testing.T) {
+	products := []models.Product{
+		{ID: "d1", Link: "https://example.com/p/1"},
+		{ID: "d1", Link: "https://example.com/p/1?utm_source=x"},
+		{ID: "d2", Link: "https://EXAMPLE.com/p/1/#top"},
+		{ID: "d3", Link: "https://example.com/p/3"},
+		{ID: "d1", Link: "https://example.com/p/other"},
+	}
+
+	tests := []struct {
+		policy  string
+		kept    []string
+		aliases []string
+	}{
+		{utils.DedupKeepFirst, []string{"d1", "d3"}, nil},
+		{utils.DedupAlias, []string{"d1", "d3"}, []string{"d2"}},
+		{utils.DedupKeepAll, []string{"d1", "d2", "d3"}, nil},
+		{utils.DedupOff, []string{"d1", "d1", "d2", "d3", "d1"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			dedup := utils.NewDeduplicator(tt.policy)
+			var kept []string
+			for _, p := range products {
+				if dedup.Add(p) {
+					kept = append(kept, p.ID)
+				}
+			}
+			if !reflect.DeepEqual(kept, tt.kept) {
+				t.Errorf("Expected kept %v, got %v", tt.kept, kept)
+			}
+
+			var aliases []string
+			dedup.Finish(models.ProductResult{ID: "d1", Success: true})
+			for _, result := range dedup.AliasResults() {
+				aliases = append(aliases, result.ID)
+			}
+			if !reflect.DeepEqual(aliases, tt.aliases) {
+				t.Errorf("Expected aliases %v, got %v", tt.aliases, aliases)
+			}
+
+			if collapsed := len(products) - len(kept); len(dedup.Records()) != collapsed {
+				t.Errorf("Expected %d dedup records, got %d", collapsed, len(dedup.Records()))
+			}
+		})
+	}
+}
+
+func TestExcelNumericIDs(t *testing.T) {
+	xlsxFile := filepath.Join(t.TempDir(), "skus.xlsx")
+
+	f := excelize.NewFile()
+	f.SetSheetRow("Sheet1", "A1", &[]interface{}{"sku", "link"})
+	f.SetCellValue("Sheet1", "A2", 1234567890123)
+	f.SetCellValue("Sheet1", "A3", 12345)
+	f.SetCellStr("Sheet1", "A4", "00012345")
+	f.SetCellValue("Sheet1", "A5", 12345678901234567)
+	f.SetCellDefault("Sheet1", "A6", "9.87654321012E+11")
+	// Zero padded number format, as used for SKUs typed into number cells
+	padded := "00000000"
+	style, err := f.NewStyle(&excelize.Style{CustomNumFmt: &padded})
+	if err != nil {
+		t.Fatalf("Failed to create style: %v", err)
+	}
+	f.SetCellStyle("Sheet1", "A3", "A3", style)
+	for row := 2; row <= 6; row++ {
+		f.SetCellValue("Sheet1", fmt.Sprintf("B%d", row), fmt.Sprintf("https://example.com/p/%d", row))
+	}
+	if err := f.SaveAs(xlsxFile); err != nil {
+		t.Fatalf("Failed to save Excel file: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts utils.InputOptions
+		ids  []string
+	}{
+		{"formats respected", utils.InputOptions{}, []string{"1234567890123", "00012345", "00012345", "12345678901234567", "987654321012"}},
+		{"id as text", utils.InputOptions{IDAsText: true}, []string{"1234567890123", "12345", "00012345", "12345678901234567", "987654321012"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			products, err := utils.LoadProductsFromExcel(xlsxFile, tt.opts)
+			if err != nil {
+				t.Fatalf("Failed to load products: %v", err)
+			}
+			var ids []string
+			for _, p := range products {
+				ids = append(ids, p.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.ids) {
+				t.Errorf("Expected IDs %q, got %q", tt.ids, ids)
+			}
+		})
+	}
+}
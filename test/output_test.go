@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/product-scraper/internal/models"
+	"github.com/product-scraper/internal/report"
+	"github.com/product-scraper/internal/storage"
+	"github.com/product-scraper/internal/utils"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestWorkbookWriteBack(t *testing.T) {
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.WorkbookOutputFile = filepath.Join(tempDir, "output", "results.xlsx")
+
+	f := excelize.NewFile()
+	f.SetSheetName("Sheet1", "Products")
+	f.SetSheetRow("Products", "A1", &[]interface{}{"id", "link", "brand"})
+	f.SetSheetRow("Products", "A2", &[]interface{}{"p1", "https://example.com/p1", "Acme"})
+	f.SetSheetRow("Products", "A3", &[]interface{}{"p2", "https://example.com/p2", "Acme"})
+	f.SetSheetRow("Products", "A4", &[]interface{}{"p3", "https://example.com/p1?utm_source=mail", "Acme"})
+	f.SetSheetRow("Products", "A5", &[]interface{}{"p4", "https://example.com/p4", "Acme"})
+	bold, _ := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	f.SetCellStyle("Products", "A1", "C1", bold)
+	f.NewSheet("Notes")
+	f.SetCellValue("Notes", "A1", "keep me")
+	if err := f.SaveAs(cfg.InputFile); err != nil {
+		t.Fatalf("Failed to save Excel file: %v", err)
+	}
+
+	sm := storage.NewManager(cfg)
+	sm.SaveResult(models.ProductResult{ID: "p1", URL: "https://example.com/p1", Success: true,
+		Images: []string{"https://example.com/1.jpg", "https://example.com/2.jpg"}})
+	sm.SaveFailedURL("p2", "https://example.com/p2", "timeout")
+	if err := sm.WriteWorkbook(utils.InputOptions{}); err != nil {
+		t.Fatalf("Failed to write workbook: %v", err)
+	}
+
+	out, err := excelize.OpenFile(cfg.WorkbookOutputFile)
+	if err != nil {
+		t.Fatalf("Failed to open output workbook: %v", err)
+	}
+	defer out.Close()
+
+	rows, err := out.GetRows("Products")
+	if err != nil {
+		t.Fatalf("Failed to read output rows: %v", err)
+	}
+	expected := [][]string{
+		{"id", "link", "brand", "image_count", "image_1", "image_2", "status", "error"},
+		{"p1", "https://example.com/p1", "Acme", "2", "https://example.com/1.jpg", "https://example.com/2.jpg", "success"},
+		{"p2", "https://example.com/p2", "Acme", "0", "", "", "failed", "timeout"},
+		{"p3", "https://example.com/p1?utm_source=mail", "Acme", "2", "https://example.com/1.jpg", "https://example.com/2.jpg", "success"},
+		{"p4", "https://example.com/p4", "Acme", "0", "", "", "not scraped"},
+	}
+	for i, want := range expected {
+		if i >= len(rows) {
+			t.Fatalf("Missing row %d", i+1)
+		}
+		for j, value := range want {
+			if j >= len(rows[i]) || rows[i][j] != value {
+				t.Errorf("Row %d column %d: expected %q, got %v", i+1, j+1, value, rows[i])
+				break
+			}
+		}
+	}
+
+	if style, _ := out.GetCellStyle("Products", "G1"); style != bold {
+		t.Errorf("Expected new headers to keep the header style")
+	}
+	if value, _ := out.GetCellValue("Notes", "A1"); value != "keep me" {
+		t.Errorf("Other sheets should be preserved, got %q", value)
+	}
+	if _, target, _ := out.GetCellHyperLink("Products", "E2"); target != "https://example.com/1.jpg" {
+		t.Errorf("Expected image cells to link to the image, got %q", target)
+	}
+
+	// Products discovered from a sitemap have no workbook to write back to
+	cfg.InputSource = "sitemap"
+	if err := sm.WriteWorkbook(utils.InputOptions{}); err == nil {
+		t.Errorf("Expected an error for sitemap input")
+	}
+
+	cfg.InputSource = "file"
+	cfg.InputFile = filepath.Join(tempDir, "input.csv")
+	if err := sm.WriteWorkbook(utils.InputOptions{}); err == nil {
+		t.Errorf("Expected an error for a non-Excel input")
+	}
+}
+
+func TestQAReport(t *testing.T) {
+	var pngData bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+	png.Encode(&pngData, img)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/1.png", "/2.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(pngData.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.ReportFile = filepath.Join(tempDir, "output", "report.xlsx")
+	cfg.ReportImageDir = filepath.Join(tempDir, "output", "report_images")
+	cfg.ReportThumbnails = 2
+
+	results := []models.ProductResult{{
+		ID:      "p1",
+		URL:     "https://example.com/p1",
+		Success: true,
+		Images:  []string{server.URL + "/1.png", server.URL + "/missing.png", server.URL + "/2.png"},
+	}}
+	failed := []models.FailedURL{{ID: "p2", URL: "https://example.com/p2", Error: "timeout"}}
+
+	if err := report.New(cfg).Write(context.Background(), results, failed); err != nil {
+		t.Fatalf("Failed to write report: %v", err)
+	}
+
+	f, err := excelize.OpenFile(cfg.ReportFile)
+	if err != nil {
+		t.Fatalf("Failed to open report: %v", err)
+	}
+	defer f.Close()
+
+	rows, _ := f.GetRows("Report")
+	if len(rows) != 3 || rows[0][5] != "Image 1" || len(rows[0]) != 7 {
+		t.Fatalf("Unexpected report layout: %v", rows)
+	}
+	if rows[1][0] != "p1" || rows[1][2] != "success" || rows[1][4] != "3" {
+		t.Errorf("Unexpected success row: %v", rows[1])
+	}
+	if rows[2][0] != "p2" || rows[2][2] != "failed" || rows[2][3] != "timeout" {
+		t.Errorf("Unexpected failure row: %v", rows[2])
+	}
+
+	pics, err := f.GetPictures("Report", "F2")
+	if err != nil || len(pics) != 1 {
+		t.Fatalf("Expected a thumbnail in F2, got %d pictures: %v", len(pics), err)
+	}
+	// The thumbnail is shrunk to the cell size instead of embedding the original
+	thumb, _, err := image.DecodeConfig(bytes.NewReader(pics[0].File))
+	if err != nil {
+		t.Fatalf("Failed to decode embedded thumbnail: %v", err)
+	}
+	if thumb.Width != 100 || thumb.Height != 75 {
+		t.Errorf("Expected a 100x75 thumbnail, got %dx%d", thumb.Width, thumb.Height)
+	}
+
+	// An image that could not be downloaded is linked instead
+	if pics, _ := f.GetPictures("Report", "G2"); len(pics) != 0 {
+		t.Errorf("Expected no thumbnail for a missing image")
+	}
+	if value, _ := f.GetCellValue("Report", "G2"); value != server.URL+"/missing.png" {
+		t.Errorf("Expected the image URL in G2, got %q", value)
+	}
+
+	// Downloads are cached for later reports
+	entries, _ := os.ReadDir(cfg.ReportImageDir)
+	if len(entries) != 1 {
+		t.Errorf("Expected 1 cached image, got %d", len(entries))
+	}
+}
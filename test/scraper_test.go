@@ -10,6 +10,8 @@ import (
 	"github.com/product-scraper/internal/config"
 	"github.com/product-scraper/internal/models"
 	"github.com/product-scraper/internal/storage"
+	"github.com/product-scraper/internal/utils"
+	"github.com/xuri/excelize/v2"
 )
 
 func setupTestEnvironment(t *testing.T) (*config.Config, string) {
@@ -30,9 +32,9 @@ func setupTestEnvironment(t *testing.T) (*config.Config, string) {
 		// SnapshotsDir:     filepath.Join(tempDir, "output", "snapshots"), // Removed
 		FinalOutputFile: filepath.Join(tempDir, "output", "final_output.json"),
 		FailedURLsFile:  filepath.Join(tempDir, "output", "failed_urls.json"),
-		// ResumeDataFile:   filepath.Join(tempDir, "output", "resume_data.json"), // Removed
-		WorkerCount: 2,
-		BufferSize:  10,
+		ResumeDataFile:  filepath.Join(tempDir, "output", "resume_data.json"),
+		WorkerCount:     2,
+		BufferSize:      10,
 		// SnapshotInterval: 5, // Removed
 		RequestTimeout: 5 * time.Second,
 		PageLoadDelay:  500 * time.Millisecond,
@@ -117,8 +119,296 @@ func TestStorageManager(t *testing.T) {
 	}
 }
 
+func TestResultMetadata(t *testing.T) {
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+
+	// The metadata of a result is written to the final output
+	sm := storage.NewManager(cfg)
+	sm.SaveResult(models.ProductResult{
+		ID:       "meta1",
+		Images:   []string{},
+		Success:  true,
+		Metadata: map[string]string{"brand": "Acme", "season": "SS25"},
+	})
+
+	if err := sm.GenerateFinalOutput(); err != nil {
+		t.Fatalf("Failed to generate final output: %v", err)
+	}
+
+	data, err := os.ReadFile(cfg.FinalOutputFile)
+	if err != nil {
+		t.Fatalf("Failed to read final output file: %v", err)
+	}
+	var finalResults []models.ProductResult
+	if err := json.Unmarshal(data, &finalResults); err != nil {
+		t.Fatalf("Failed to parse final output file: %v", err)
+	}
+	if len(finalResults) != 1 || finalResults[0].Metadata["brand"] != "Acme" || finalResults[0].Metadata["season"] != "SS25" {
+		t.Errorf("Expected metadata on final output, got %+v", finalResults)
+	}
+}
+
+func TestResume(t *testing.T) {
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.CheckpointInterval = 2
+
+	// First run is interrupted after two products; the checkpoint is written
+	// automatically every CheckpointInterval products
+	sm := storage.NewManager(cfg)
+	sm.SaveResult(models.ProductResult{ID: "p1", Images: []string{"https://example.com/1.jpg"}, Success: true})
+	sm.SaveFailedURL("p2", "https://example.com/p2", "timeout")
+
+	if _, err := os.Stat(cfg.ResumeDataFile); err != nil {
+		t.Fatalf("Resume data was not checkpointed: %v", err)
+	}
+
+	// Second run restores the partial results and skips completed products
+	sm = storage.NewManager(cfg)
+	completed, err := sm.LoadResumeData()
+	if err != nil {
+		t.Fatalf("Failed to load resume data: %v", err)
+	}
+	if completed != 2 || !sm.IsCompleted("p1") || !sm.IsCompleted("p2") || sm.IsCompleted("p3") {
+		t.Errorf("Unexpected completed products: %d", completed)
+	}
+
+	sm.SaveResult(models.ProductResult{ID: "p3", Images: []string{"https://example.com/3.jpg"}, Success: true})
+	if err := sm.GenerateFinalOutput(); err != nil {
+		t.Fatalf("Failed to generate final output: %v", err)
+	}
+	if err := sm.ClearResumeData(); err != nil {
+		t.Fatalf("Failed to clear resume data: %v", err)
+	}
+
+	data, err := os.ReadFile(cfg.FinalOutputFile)
+	if err != nil {
+		t.Fatalf("Failed to read final output: %v", err)
+	}
+	var results []models.ProductResult
+	json.Unmarshal(data, &results)
+	if len(results) != 2 || results[0].ID != "p1" || results[1].ID != "p3" {
+		t.Errorf("Expected merged results p1 and p3, got %+v", results)
+	}
+
+	data, err = os.ReadFile(cfg.FailedURLsFile)
+	if err != nil {
+		t.Fatalf("Failed to read failed URLs: %v", err)
+	}
+	var failed []models.FailedURL
+	json.Unmarshal(data, &failed)
+	if len(failed) != 1 || failed[0].ID != "p2" {
+		t.Errorf("Expected failed URL p2 from the previous run, got %+v", failed)
+	}
+
+	if _, err := os.Stat(cfg.ResumeDataFile); !os.IsNotExist(err) {
+		t.Errorf("Resume data should be removed after a completed run")
+	}
+
+	// Nothing to resume is not an error
+	if completed, err := storage.NewManager(cfg).LoadResumeData(); err != nil || completed != 0 {
+		t.Errorf("Expected empty resume, got %d, %v", completed, err)
+	}
+}
+
+func TestRetryFailed(t *testing.T) {
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+
+	// Previous run: p1 succeeded, p2 and p3 failed
+	sm := storage.NewManager(cfg)
+	sm.SaveResult(models.ProductResult{ID: "p1", Images: []string{"https://example.com/1.jpg"}, Success: true})
+	sm.SaveFailedURL("p2", "https://example.com/p2", "timeout")
+	sm.SaveFailedURL("p3", "https://example.com/p3", "timeout")
+	if err := sm.GenerateFinalOutput(); err != nil {
+		t.Fatalf("Failed to generate final output: %v", err)
+	}
+
+	sm = storage.NewManager(cfg)
+	failedURLs, err := sm.LoadPreviousRun()
+	if err != nil {
+		t.Fatalf("Failed to load previous run: %v", err)
+	}
+
+	products := utils.FilterFailedURLs([]models.Product{
+		{ID: "p1", Link: "https://example.com/p1"},
+		{ID: "p2", Link: "https://example.com/p2"},
+		{ID: "p3", Link: "https://example.com/p3"},
+	}, failedURLs)
+	if len(products) != 2 || products[0].ID != "p2" || products[1].ID != "p3" {
+		t.Fatalf("Expected only the failed products, got %+v", products)
+	}
+
+	// Retry: p2 now succeeds, p3 fails again
+	sm.SaveResult(models.ProductResult{ID: "p2", Images: []string{"https://example.com/2.jpg"}, Success: true})
+	sm.SaveFailedURL("p3", "https://example.com/p3", "status 404")
+	if err := sm.GenerateFinalOutput(); err != nil {
+		t.Fatalf("Failed to generate final output: %v", err)
+	}
+
+	var results []models.ProductResult
+	data, _ := os.ReadFile(cfg.FinalOutputFile)
+	json.Unmarshal(data, &results)
+	if len(results) != 2 || results[0].ID != "p1" || results[1].ID != "p2" {
+		t.Errorf("Expected merged results p1 and p2, got %+v", results)
+	}
+
+	var failed []models.FailedURL
+	data, _ = os.ReadFile(cfg.FailedURLsFile)
+	json.Unmarshal(data, &failed)
+	if len(failed) != 1 || failed[0].ID != "p3" {
+		t.Fatalf("Expected only p3 to remain failed, got %+v", failed)
+	}
+	if failed[0].Attempts != 2 || failed[0].Error != "status 404" {
+		t.Errorf("Expected 2 attempts with the latest error, got %d %q", failed[0].Attempts, failed[0].Error)
+	}
+}
+
+func TestJournalRecovery(t *testing.T) {
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.JournalFile = filepath.Join(tempDir, "output", "results.jsonl")
+	cfg.JournalSyncInterval = 1
+	cfg.CheckpointInterval = 1
+
+	// A run that crashes after three products, mid-way through a fourth write
+	sm := storage.NewManager(cfg)
+	if err := sm.OpenJournal(); err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	sm.SaveResult(models.ProductResult{ID: "p1", Images: []string{"https://example.com/1.jpg"}, Success: true})
+	sm.SaveFailedURL("p2", "https://example.com/p2", "timeout")
+	sm.SaveFailedURL("p3", "https://example.com/p3", "timeout")
+	sm.SaveResult(models.ProductResult{ID: "p3", Images: []string{"https://example.com/3.jpg"}, Success: true})
+
+	journal, err := os.OpenFile(cfg.JournalFile, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	journal.WriteString(`{"result":{"id":"p4","ima`)
+	journal.Close()
+
+	if _, err := os.Stat(cfg.FinalOutputFile); !os.IsNotExist(err) {
+		t.Fatalf("Final output should not exist before recovery")
+	}
+	// The journal replaces periodic checkpoints
+	if _, err := os.Stat(cfg.ResumeDataFile); !os.IsNotExist(err) {
+		t.Errorf("No checkpoint should be written while the journal is enabled")
+	}
+
+	sm = storage.NewManager(cfg)
+	recovered, err := sm.RecoverJournal()
+	if err != nil {
+		t.Fatalf("Failed to recover journal: %v", err)
+	}
+	if recovered != 3 || !sm.IsCompleted("p2") || sm.IsCompleted("p4") {
+		t.Errorf("Unexpected recovery: %d products", recovered)
+	}
+
+	// Reopening compacts the journal and later results are appended to it
+	if err := sm.OpenJournal(); err != nil {
+		t.Fatalf("Failed to reopen journal: %v", err)
+	}
+	sm.SaveFailedURL("p2", "https://example.com/p2", "status 500")
+	if err := sm.GenerateFinalOutput(); err != nil {
+		t.Fatalf("Failed to generate final output: %v", err)
+	}
+
+	var results []models.ProductResult
+	data, _ := os.ReadFile(cfg.FinalOutputFile)
+	json.Unmarshal(data, &results)
+	if len(results) != 2 || results[0].ID != "p1" || results[1].ID != "p3" {
+		t.Errorf("Expected results p1 and p3, got %+v", results)
+	}
+
+	var failed []models.FailedURL
+	data, _ = os.ReadFile(cfg.FailedURLsFile)
+	json.Unmarshal(data, &failed)
+	if len(failed) != 1 || failed[0].ID != "p2" || failed[0].Attempts != 2 {
+		t.Errorf("Expected p2 failed twice, got %+v", failed)
+	}
+}
+
+func TestFailedAttemptHistory(t *testing.T) {
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+
+	attempt := func(worker int, err string) models.Attempt {
+		return models.Attempt{Timestamp: time.Now(), DurationMS: 15000, Error: err, WorkerID: worker}
+	}
+
+	sm := storage.NewManager(cfg)
+	sm.SaveFailedResult(models.ProductResult{
+		ID:       "p1",
+		URL:      "https://example.com/p1",
+		Error:    "timeout",
+		Attempts: []models.Attempt{attempt(0, "timeout"), attempt(0, "net::ERR_CONNECTION_RESET"), attempt(0, "timeout")},
+	})
+	// A later run fails again on another worker
+	sm.SaveFailedResult(models.ProductResult{
+		ID:       "p1",
+		URL:      "https://example.com/p1",
+		Error:    "status 404",
+		Attempts: []models.Attempt{attempt(3, "status 404")},
+	})
+	if err := sm.GenerateFinalOutput(); err != nil {
+		t.Fatalf("Failed to generate final output: %v", err)
+	}
+
+	var failed []models.FailedURL
+	data, _ := os.ReadFile(cfg.FailedURLsFile)
+	if err := json.Unmarshal(data, &failed); err != nil {
+		t.Fatalf("Failed to parse failed URLs file: %v", err)
+	}
+	if len(failed) != 1 {
+		t.Fatalf("Expected 1 failed URL, got %d", len(failed))
+	}
+	got := failed[0]
+	if got.URL != "https://example.com/p1" || got.Error != "status 404" {
+		t.Errorf("Unexpected URL or error: %q %q", got.URL, got.Error)
+	}
+	if got.Attempts != 4 || len(got.History) != 4 {
+		t.Fatalf("Expected 4 attempts in the history, got %d and %d", got.Attempts, len(got.History))
+	}
+	if got.History[1].Error != "net::ERR_CONNECTION_RESET" || got.History[3].WorkerID != 3 {
+		t.Errorf("Unexpected history: %+v", got.History)
+	}
+}
+
 func TestProductLoading(t *testing.T) {
-	// This is a placeholder for a test that would create a test Excel file
-	// and verify that products are loaded correctly
-	t.Skip("Implement this test with a real Excel file")
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+
+	f := excelize.NewFile()
+	rows := [][]interface{}{
+		{"Product_ID", "Name", "URL"},
+		{"p1", "First", "https://example.com/p1"},
+		{"p2", "No link"},
+		{},
+		{"p3", "Third", "https://example.com/p3"},
+	}
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := f.SetSheetRow("Sheet1", cell, &row); err != nil {
+			t.Fatalf("Failed to write row: %v", err)
+		}
+	}
+	if err := f.SaveAs(cfg.InputFile); err != nil {
+		t.Fatalf("Failed to save Excel file: %v", err)
+	}
+
+	products, err := utils.LoadProductsFromExcel(cfg.InputFile, utils.InputOptions{})
+	if err != nil {
+		t.Fatalf("Failed to load products: %v", err)
+	}
+	if len(products) != 2 {
+		t.Fatalf("Expected 2 products, got %d", len(products))
+	}
+	if products[1].ID != "p3" || products[1].Link != "https://example.com/p3" {
+		t.Errorf("Unexpected product: %+v", products[1])
+	}
+	if products[1].Metadata["Name"] != "Third" {
+		t.Errorf("Expected Name metadata, got %v", products[1].Metadata)
+	}
 }
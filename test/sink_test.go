@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/product-scraper/internal/models"
+	"github.com/product-scraper/internal/storage"
+	"github.com/product-scraper/internal/utils"
+)
+
+func TestFileSinks(t *testing.T) {
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.OutputSinks = []string{"json", "jsonl", "csv"}
+	cfg.JSONLOutputFile = filepath.Join(tempDir, "output", "final_output.jsonl")
+	cfg.CSVOutputFile = filepath.Join(tempDir, "output", "final_output.csv")
+
+	success := models.ProductResult{
+		ID:       "p1",
+		URL:      "https://example.com/p1",
+		Images:   []string{"https://example.com/1.jpg", "https://example.com/2.jpg"},
+		Success:  true,
+		Metadata: map[string]string{"brand": "Acme"},
+	}
+	failure := models.ProductResult{ID: "p2", URL: "https://example.com/p2", Error: "timeout",
+		Attempts: []models.Attempt{{Error: "timeout"}}}
+
+	sm := storage.NewManager(cfg)
+	sinks, err := storage.NewSinks(cfg, sm, false)
+	if err != nil {
+		t.Fatalf("Failed to create sinks: %v", err)
+	}
+	if len(sinks) != 3 {
+		t.Fatalf("Expected the manager plus 2 sinks, got %d", len(sinks))
+	}
+	for _, sink := range sinks {
+		sink.WriteResult(success)
+		sink.WriteFailure(failure)
+		if err := sink.Close(); err != nil {
+			t.Fatalf("Failed to close sink: %v", err)
+		}
+	}
+
+	// A resumed run appends to the file sinks
+	sinks, err = storage.NewSinks(cfg, storage.NewManager(cfg), true)
+	if err != nil {
+		t.Fatalf("Failed to reopen sinks: %v", err)
+	}
+	for _, sink := range sinks[1:] {
+		sink.WriteResult(models.ProductResult{ID: "p3", Success: true})
+		sink.Close()
+	}
+
+	file, err := os.Open(cfg.JSONLOutputFile)
+	if err != nil {
+		t.Fatalf("Failed to open JSONL output: %v", err)
+	}
+	defer file.Close()
+	var ids []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var result models.ProductResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatalf("Invalid JSONL line %q: %v", scanner.Text(), err)
+		}
+		ids = append(ids, result.ID)
+	}
+	if len(ids) != 3 || ids[0] != "p1" || ids[1] != "p2" || ids[2] != "p3" {
+		t.Errorf("Unexpected JSONL results: %v", ids)
+	}
+
+	csvFile, err := os.Open(cfg.CSVOutputFile)
+	if err != nil {
+		t.Fatalf("Failed to open CSV output: %v", err)
+	}
+	defer csvFile.Close()
+	rows, err := csv.NewReader(csvFile).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read CSV output: %v", err)
+	}
+	if len(rows) != 4 || rows[0][0] != "id" {
+		t.Fatalf("Expected a header and 3 rows, got %v", rows)
+	}
+	if rows[1][6] != "2" || rows[1][7] != "https://example.com/1.jpg https://example.com/2.jpg" || rows[1][8] != `{"brand":"Acme"}` {
+		t.Errorf("Unexpected success row: %v", rows[1])
+	}
+	if rows[2][3] != "false" || rows[2][4] != "timeout" || rows[2][5] != "1" {
+		t.Errorf("Unexpected failure row: %v", rows[2])
+	}
+
+	// The manager still writes the JSON files
+	if _, err := os.Stat(cfg.FinalOutputFile); err != nil {
+		t.Errorf("Final output was not written: %v", err)
+	}
+
+	cfg.OutputSinks = []string{"xml"}
+	if _, err := storage.NewSinks(cfg, sm, false); err == nil {
+		t.Errorf("Expected an error for an unknown sink")
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var mutex sync.Mutex
+	var batches [][]models.ProductResult
+	fail := 1
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		// The first request fails and must be retried
+		if fail > 0 {
+			fail--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch struct {
+			Results []models.ProductResult `json:"results"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		batches = append(batches, batch.Results)
+	}))
+	defer server.Close()
+
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.HTTPSinkURL = server.URL
+	cfg.HTTPSinkToken = "secret"
+	cfg.HTTPSinkBatchSize = 2
+	cfg.MaxRetries = 2
+	cfg.RetryDelay = 0
+
+	sink, err := storage.NewHTTPSink(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP sink: %v", err)
+	}
+	for _, id := range []string{"p1", "p2"} {
+		if err := sink.WriteResult(models.ProductResult{ID: id, Success: true}); err != nil {
+			t.Fatalf("Failed to write result: %v", err)
+		}
+	}
+	if err := sink.WriteFailure(models.ProductResult{ID: "p3", Error: "timeout"}); err != nil {
+		t.Fatalf("Failed to write failure: %v", err)
+	}
+	if len(batches) != 1 {
+		t.Fatalf("Expected one full batch before closing, got %d", len(batches))
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close sink: %v", err)
+	}
+
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("Unexpected batches: %+v", batches)
+	}
+	if batches[1][0].ID != "p3" || batches[1][0].Success {
+		t.Errorf("Expected the failure in the last batch, got %+v", batches[1][0])
+	}
+
+	cfg.HTTPSinkURL = ""
+	if _, err := storage.NewHTTPSink(cfg); err == nil {
+		t.Errorf("Expected an error without HTTP_SINK_URL")
+	}
+}
+
+func TestAliasResultsWithStreamedInput(t *testing.T) {
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+
+	manager := storage.NewManager(cfg)
+	sinks, err := storage.NewSinks(cfg, manager, false)
+	if err != nil {
+		t.Fatalf("Failed to create sinks: %v", err)
+	}
+	dedup := utils.NewDeduplicator(utils.DedupAlias)
+
+	input := []models.Product{
+		{ID: "a1", Link: "https://example.com/p/1"},
+		{ID: "a2", Link: "https://example.com/p/1?utm_source=x"}, // read before a1 finishes
+		{ID: "b1", Link: "https://example.com/p/2"},
+		{ID: "a3", Link: "https://example.com/p/1#reviews"}, // read after a1 finished
+		{ID: "b2", Link: "https://example.com/p/2/"},        // read after b1 failed
+	}
+	done := func(id string) bool {
+		for _, result := range manager.Results() {
+			if result.ID == id {
+				return true
+			}
+		}
+		for _, failed := range manager.FailedURLs() {
+			if failed.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	ctx := context.Background()
+	productChan := make(chan models.Product)
+	resultChan := make(chan models.ProductResult)
+	var wg sync.WaitGroup
+
+	// Producer, reading the duplicates of a finished product only once its
+	// result is written, as happens with large streamed workbooks
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(productChan)
+		for _, product := range input {
+			if product.ID == "a3" || product.ID == "b2" {
+				deadline := time.Now().Add(5 * time.Second)
+				for !done(product.ID[:1]+"1") && time.Now().Before(deadline) {
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+			if dedup.Add(product) {
+				productChan <- product
+			}
+		}
+	}()
+
+	// Worker: b1 fails, everything else succeeds
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(resultChan)
+		for product := range productChan {
+			result := models.ProductResult{
+				ID:      product.ID,
+				URL:     product.Link,
+				Images:  []string{"https://cdn.example.com/" + product.ID + ".jpg"},
+				Success: true,
+			}
+			if product.ID == "b1" {
+				result.Images, result.Success, result.Error = nil, false, "timeout"
+			}
+			resultChan <- result
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		storage.ProcessResults(ctx, resultChan, sinks, dedup)
+	}()
+
+	wg.Wait()
+	storage.WriteAliasResults(sinks, dedup)
+
+	results := make(map[string]models.ProductResult)
+	for _, result := range manager.Results() {
+		results[result.ID] = result
+	}
+	for _, id := range []string{"a1", "a2", "a3"} {
+		result, ok := results[id]
+		if !ok || len(result.Images) != 1 || result.Images[0] != "https://cdn.example.com/a1.jpg" {
+			t.Errorf("Expected %s to share the result of a1, got %+v", id, result)
+		}
+	}
+	if results["a3"].URL != "https://example.com/p/1#reviews" {
+		t.Errorf("Expected the alias to keep its own link, got %s", results["a3"].URL)
+	}
+
+	failed := make(map[string]bool)
+	for _, f := range manager.FailedURLs() {
+		failed[f.ID] = true
+	}
+	if !failed["b1"] || !failed["b2"] {
+		t.Errorf("Expected b1 and its late duplicate b2 to be failed, got %v", failed)
+	}
+}
+
+func TestAliasResultsAfterResume(t *testing.T) {
+	cfg, tempDir := setupTestEnvironment(t)
+	defer cleanupTestEnvironment(t, tempDir)
+	cfg.JournalFile = filepath.Join(tempDir, "output", "results.jsonl")
+
+	// The previous run finished a1, its alias a2 and c1, then crashed
+	previous := storage.NewManager(cfg)
+	if err := previous.OpenJournal(); err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	images := []string{"https://cdn.example.com/1.jpg"}
+	previous.SaveResult(models.ProductResult{ID: "a1", URL: "https://example.com/p/1", Images: images, Success: true})
+	previous.SaveResult(models.ProductResult{ID: "a2", URL: "https://example.com/p/1?utm_source=x", Images: images, Success: true})
+	previous.SaveResult(models.ProductResult{ID: "c1", URL: "https://example.com/p/3", Success: true})
+
+	manager := storage.NewManager(cfg)
+	if _, err := manager.RecoverJournal(); err != nil {
+		t.Fatalf("Failed to recover journal: %v", err)
+	}
+	dedup := utils.NewDeduplicator(utils.DedupAlias)
+	storage.SeedAliasResults(manager, dedup)
+
+	input := []models.Product{
+		{ID: "a1", Link: "https://example.com/p/1"},
+		{ID: "a2", Link: "https://example.com/p/1?utm_source=x"},
+		{ID: "a3", Link: "https://example.com/p/1#reviews"},
+		{ID: "c1", Link: "https://example.com/p/3"},
+		{ID: "c2", Link: "https://example.com/p/3/"},
+	}
+	var scraped []string
+	for _, product := range input {
+		if manager.IsCompleted(product.ID) {
+			dedup.AddCompleted(product)
+			continue
+		}
+		if dedup.Add(product) {
+			scraped = append(scraped, product.ID)
+		}
+	}
+
+	if len(scraped) != 0 {
+		t.Errorf("Expected every product to be resumed or aliased, scraped %v", scraped)
+	}
+	aliases := dedup.AliasResults()
+	if len(aliases) != 2 {
+		t.Fatalf("Expected alias results for a3 and c2 only, got %+v", aliases)
+	}
+	if a3 := aliases[0]; a3.ID != "a3" || a3.URL != input[2].Link || !a3.Success || len(a3.Images) != 1 {
+		t.Errorf("Expected a3 to get the stored result of a1, got %+v", a3)
+	}
+	if c2 := aliases[1]; c2.ID != "c2" || !c2.Success {
+		t.Errorf("Expected c2 to get the stored result of c1, got %+v", c2)
+	}
+
+	// Without a stored result there is nothing to copy, so duplicates of a
+	// completed product are scraped
+	unseeded := utils.NewDeduplicator(utils.DedupAlias)
+	unseeded.AddCompleted(input[0])
+	if !unseeded.Add(input[2]) {
+		t.Error("Expected a3 to be scraped when a1's result is unavailable")
+	}
+}